@@ -0,0 +1,157 @@
+// Package acl manages users, their passwords, allowed commands and key patterns, like redis 6 ACL
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is the user of connections which never sent AUTH with a username
+const DefaultUser = "default"
+
+var (
+	mu    sync.RWMutex
+	users = map[string]*User{DefaultUser: makeDefaultUser("")}
+)
+
+// makeDefaultUser creates the default user, it requires password if requirePass is not empty
+func makeDefaultUser(requirePass string) *User {
+	u := newUser(DefaultUser)
+	rules := []string{"on", "~*", "+@all"}
+	if requirePass == "" {
+		rules = append(rules, "nopass")
+	} else {
+		rules = append(rules, ">"+requirePass)
+	}
+	for _, rule := range rules {
+		_ = u.setRule(rule)
+	}
+	return u
+}
+
+// Setup resets users, the default user uses requirePass as its password, then users in aclFile are loaded
+func Setup(requirePass string, aclFile string) error {
+	loaded := map[string]*User{DefaultUser: makeDefaultUser(requirePass)}
+	if aclFile != "" {
+		fileUsers, err := loadFile(aclFile)
+		if err != nil {
+			return err
+		}
+		for name, u := range fileUsers {
+			loaded[name] = u
+		}
+	}
+	mu.Lock()
+	users = loaded
+	mu.Unlock()
+	return nil
+}
+
+// loadFile parses an aclfile, each line is like `user <name> [rule ...]`, which is the output of `ACL LIST`
+func loadFile(filename string) (map[string]*User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	loaded := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword", filename, lineNo)
+		}
+		name := fields[1]
+		if _, ok := loaded[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s'", filename, lineNo, name)
+		}
+		u := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := u.setRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: error in user declaration '%s': %v", filename, lineNo, rule, err)
+			}
+		}
+		loaded[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// GetUser returns the user, nil if not exists
+func GetUser(name string) *User {
+	mu.RLock()
+	defer mu.RUnlock()
+	return users[name]
+}
+
+// Users returns all users sorted by name
+func Users() []*User {
+	mu.RLock()
+	result := make([]*User, 0, len(users))
+	for _, u := range users {
+		result = append(result, u)
+	}
+	mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// SetUser creates the user or modifies it by rules, the user is not changed if any rule is invalid
+func SetUser(name string, rules []string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	var u *User
+	if old, ok := users[name]; ok {
+		u = old.copy()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	users[name] = u
+	return nil
+}
+
+// DelUser removes users and returns the number of removed users, the default user can not be removed
+func DelUser(names []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, errors.New("ERR The 'default' user cannot be removed")
+		}
+	}
+	count := 0
+	for _, name := range names {
+		if _, ok := users[name]; ok {
+			delete(users, name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Authenticate returns the user if it is enabled and accepts the password, otherwise nil
+func Authenticate(name string, password string) *User {
+	u := GetUser(name)
+	if u == nil || !u.Enabled() || !u.CheckPassword(password) {
+		return nil
+	}
+	return u
+}
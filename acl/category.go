@@ -0,0 +1,111 @@
+package acl
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// command categories, a rule like `+@read` allows all commands in the category
+const (
+	CatKeyspace    = "keyspace"
+	CatRead        = "read"
+	CatWrite       = "write"
+	CatString      = "string"
+	CatList        = "list"
+	CatHash        = "hash"
+	CatSet         = "set"
+	CatSortedSet   = "sortedset"
+	CatStream      = "stream"
+	CatAdmin       = "admin"
+	CatDangerous   = "dangerous"
+	CatConnection  = "connection"
+	CatTransaction = "transaction"
+	CatPubSub      = "pubsub"
+	CatBlocking    = "blocking"
+)
+
+// catAll is the pseudo category matching every command
+const catAll = "all"
+
+var categoryList = []string{
+	CatKeyspace, CatRead, CatWrite, CatString, CatList, CatHash, CatSet, CatSortedSet, CatStream,
+	CatAdmin, CatDangerous, CatConnection, CatTransaction, CatPubSub, CatBlocking,
+}
+
+var (
+	commandsMu sync.RWMutex
+	// command name -> categories of the command
+	commandCategories = make(map[string][]string)
+)
+
+// RegisterCommand attaches categories to a command, commands must be registered before they can be used in rules
+func RegisterCommand(name string, categories ...string) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	commandCategories[strings.ToLower(name)] = categories
+}
+
+// IsCommand tells whether the command is registered
+func IsCommand(name string) bool {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	_, ok := commandCategories[name]
+	return ok
+}
+
+func isCategory(category string) bool {
+	if category == catAll {
+		return true
+	}
+	for _, c := range categoryList {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// inCategory tells whether the command belongs to the category
+func inCategory(name string, category string) bool {
+	if category == catAll {
+		return true
+	}
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	return inCategoryLocked(name, category)
+}
+
+// Categories returns names of all categories
+func Categories() []string {
+	result := make([]string, len(categoryList))
+	copy(result, categoryList)
+	return result
+}
+
+// CommandsInCategory returns sorted names of commands in the category, ok is false if the category doesn't exist
+func CommandsInCategory(category string) (commands []string, ok bool) {
+	category = strings.ToLower(category)
+	if !isCategory(category) {
+		return nil, false
+	}
+	commandsMu.RLock()
+	for name := range commandCategories {
+		if category == catAll || inCategoryLocked(name, category) {
+			commands = append(commands, name)
+		}
+	}
+	commandsMu.RUnlock()
+	sort.Strings(commands)
+	return commands, true
+}
+
+// inCategoryLocked is inCategory without locking, caller should hold commandsMu
+func inCategoryLocked(name string, category string) bool {
+	for _, c := range commandCategories[name] {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
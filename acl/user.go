@@ -0,0 +1,277 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-redis/lib/wildcard"
+	"sort"
+	"strings"
+)
+
+// User is an acl user, it is never modified after added into the registry, SETUSER replaces it with a modified copy
+type User struct {
+	Name    string
+	enabled bool
+	noPass  bool
+	// passwords holds sha256 of passwords in hex
+	passwords map[string]struct{}
+
+	// commandRules are rules like +get, -@write, applied in order to decide whether a command is allowed.
+	// rules before the last +@all or -@all are dropped
+	commandRules []string
+
+	allKeys     bool
+	keyPatterns []string
+	keyMatchers []*wildcard.Pattern
+}
+
+// newUser creates a user which is disabled and can do nothing, like `ACL SETUSER` does for new users
+func newUser(name string) *User {
+	return &User{
+		Name:      name,
+		passwords: make(map[string]struct{}),
+	}
+}
+
+func (u *User) copy() *User {
+	passwords := make(map[string]struct{}, len(u.passwords))
+	for hash := range u.passwords {
+		passwords[hash] = struct{}{}
+	}
+	return &User{
+		Name:         u.Name,
+		enabled:      u.enabled,
+		noPass:       u.noPass,
+		passwords:    passwords,
+		commandRules: append([]string(nil), u.commandRules...),
+		allKeys:      u.allKeys,
+		keyPatterns:  append([]string(nil), u.keyPatterns...),
+		keyMatchers:  append([]*wildcard.Pattern(nil), u.keyMatchers...),
+	}
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// setRule applies a rule of `ACL SETUSER` on the user
+func (u *User) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.noPass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		return u.setRule("~*")
+	case "resetkeys":
+		u.allKeys = false
+		u.keyPatterns = nil
+		u.keyMatchers = nil
+		return nil
+	case "allcommands":
+		return u.setRule("+@all")
+	case "nocommands":
+		return u.setRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "-@all"} {
+			_ = u.setRule(r)
+		}
+		return nil
+	}
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+	switch rule[0] {
+	case '>':
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.noPass = false
+	case '<':
+		hash := hashPassword(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash)
+	case '#':
+		hash := strings.ToLower(rule[1:])
+		if !isPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.passwords[hash] = struct{}{}
+		u.noPass = false
+	case '!':
+		hash := strings.ToLower(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash)
+	case '~':
+		pattern := rule[1:]
+		if u.allKeys {
+			return nil
+		}
+		if pattern == "*" {
+			u.allKeys = true
+			u.keyPatterns = nil
+			u.keyMatchers = nil
+			return nil
+		}
+		u.keyPatterns = append(u.keyPatterns, pattern)
+		u.keyMatchers = append(u.keyMatchers, wildcard.CompilePattern(pattern))
+	case '+', '-':
+		return u.setCommandRule(rule)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (u *User) setCommandRule(rule string) error {
+	name := strings.ToLower(rule[1:])
+	if strings.HasPrefix(name, "@") {
+		if !isCategory(name[1:]) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		if name[1:] == catAll {
+			// +@all 和 -@all 覆盖之前所有的规则
+			u.commandRules = nil
+			if rule[0] == '-' {
+				return nil
+			}
+		}
+	} else if !IsCommand(name) {
+		return errors.New("Unknown command or category name in ACL")
+	}
+	u.commandRules = append(u.commandRules, rule[:1]+name)
+	return nil
+}
+
+// Enabled tells whether the user can authenticate
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass tells whether any password is accepted
+func (u *User) NoPass() bool {
+	return u.noPass
+}
+
+// CheckPassword tells whether the password is accepted
+func (u *User) CheckPassword(password string) bool {
+	if u.noPass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(password)]
+	return ok
+}
+
+// CanRun tells whether the user is allowed to run the command
+func (u *User) CanRun(cmdName string) bool {
+	cmdName = strings.ToLower(cmdName)
+	allowed := false
+	for _, rule := range u.commandRules {
+		target := rule[1:]
+		var match bool
+		if strings.HasPrefix(target, "@") {
+			match = inCategory(cmdName, target[1:])
+		} else {
+			match = target == cmdName
+		}
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// CanAccess tells whether the user is allowed to access the key
+func (u *User) CanAccess(key string) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, matcher := range u.keyMatchers {
+		if matcher.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags returns flags shown by `ACL GETUSER`
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.noPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// Passwords returns sorted sha256 of passwords in hex
+func (u *User) Passwords() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Commands describes the command rules, like "+@read -keys"
+func (u *User) Commands() string {
+	if len(u.commandRules) == 0 {
+		return "-@all"
+	}
+	rules := strings.Join(u.commandRules, " ")
+	if u.commandRules[0] != "+@all" {
+		rules = "-@all " + rules
+	}
+	return rules
+}
+
+// Keys describes the key patterns, like "~report:* ~tmp:*"
+func (u *User) Keys() string {
+	if u.allKeys {
+		return "~*"
+	}
+	patterns := make([]string, len(u.keyPatterns))
+	for i, pattern := range u.keyPatterns {
+		patterns[i] = "~" + pattern
+	}
+	return strings.Join(patterns, " ")
+}
+
+// Describe returns rules which rebuild the user, it is used by `ACL LIST` and the aclfile
+func (u *User) Describe() string {
+	parts := []string{"user", u.Name}
+	parts = append(parts, u.Flags()...)
+	for _, hash := range u.Passwords() {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.Keys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	parts = append(parts, u.Commands())
+	return strings.Join(parts, " ")
+}
@@ -1,16 +1,24 @@
 package aof
 
 import (
+	"bufio"
+	"fmt"
 	"go-redis/config"
 	databaseface "go-redis/interface/database"
 	"go-redis/lib/logger"
+	"go-redis/lib/sync/atomic"
 	"go-redis/lib/utils"
+	"go-redis/rdb"
 	"go-redis/resp/connection"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
@@ -20,6 +28,15 @@ const (
 	aofQueueSize = 1 << 16
 )
 
+const (
+	// FsyncAlways do fsync for every command
+	FsyncAlways = "always"
+	// FsyncEverySec do fsync every second
+	FsyncEverySec = "everysec"
+	// FsyncNo lets operating system decides when to do fsync
+	FsyncNo = "no"
+)
+
 type payload struct {
 	cmdLine CmdLine //指令本身
 	dbIndex int     //写入哪个db
@@ -27,97 +44,397 @@ type payload struct {
 
 // AofHandler receive msgs from channel and write to AOF file
 type AofHandler struct { //全局只有一个
-	db          databaseface.Database
-	aofChan     chan *payload //作为aof的缓冲区
-	aofFile     *os.File
+	db          databaseface.DBEngine
+	tmpDBMaker  func() databaseface.DBEngine // makes an empty db to replay aof when rewriting
+	aofChan     chan *payload                //作为aof的缓冲区
+	aofDir      string
 	aofFilename string
-	currentDB   int //记录在哪个分数据库
+	aofFsync    string
+	// aofFile is the last incr file, commands are appended to it
+	aofFile *os.File
+	// manifest lists the files of multi part aof, protected by pausingAof
+	manifest  *manifest
+	currentDB int //记录在哪个分数据库
+	// pausingAof protects aofFile, manifest and currentDB, writing and fsync are serialized by it
+	pausingAof sync.Mutex
+	// closeMu is held by Close, AddAof holds its read lock, so nothing is sent to aofChan or written after Close.
+	// AddAof can't hold pausingAof while sending, handleAof needs it to consume a full channel
+	closeMu sync.RWMutex
+	// aofFinished is closed when handleAof finished
+	aofFinished chan struct{}
+	// stopCh is closed when handler is closed, it stops background goroutines
+	stopCh chan struct{}
+
+	statsMu sync.Mutex
+	stats   FsyncStats
+	// unsyncedSince is the time of the first write after the last successful fsync, zero means all synced
+	unsyncedSince time.Time
+
+	// aofSize is the total size of base and incr files, baseSize is the size after the last rewrite, used by auto rewrite
+	aofSize  int64
+	baseSize int64
+	// rewriting is true while a rewrite is in progress
+	rewriting atomic.Boolean
+	// closed is set by Close while holding both closeMu and pausingAof
+	closed bool
+}
+
+// FsyncStats reports the health of aof fsync, so that we can alert on them
+type FsyncStats struct {
+	Policy         string
+	LastFsyncTime  time.Time     // last successful fsync
+	Lag            time.Duration // how long the oldest unsynced write has been waiting
+	FsyncErrors    int64         // number of failed fsync
+	LastFsyncError error         // nil if the last fsync succeeded
+	WriteErrors    int64
+	LastWriteError error
+	DelayedFsyncs  int64 // number of fsync took longer than one second
+	LastFsyncCost  time.Duration
 }
 
 // NewAOFHandler creates a new aof.AofHandler
-func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
+func NewAOFHandler(db databaseface.DBEngine, tmpDBMaker func() databaseface.DBEngine) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.tmpDBMaker = tmpDBMaker
+	handler.aofDir = aofDirname()
+	handler.aofFilename = aofFilename()
+	handler.aofFsync = strings.ToLower(config.Properties.AppendFsync)
+	switch handler.aofFsync {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		handler.aofFsync = FsyncEverySec
+	}
+	handler.stats.Policy = handler.aofFsync
 	handler.db = db
-	//加载
-	handler.LoadAof()
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	m, err := openManifest(handler.aofDir, handler.aofFilename)
 	if err != nil {
 		return nil, err
 	}
-	handler.aofFile = aofFile
+	removeUnreferenced(handler.aofDir, handler.aofFilename, m)
+	handler.manifest = m
+	//加载
+	if err := handler.LoadAof(); err != nil {
+		return nil, err
+	}
+	if err := handler.openIncrFile(); err != nil {
+		return nil, err
+	}
+	handler.aofSize = handler.filesSize(handler.manifest.files())
+	handler.baseSize = handler.aofSize
 	//创建管道
 	handler.aofChan = make(chan *payload, aofQueueSize)
+	handler.aofFinished = make(chan struct{})
+	handler.stopCh = make(chan struct{})
 	go func() {
 		handler.handleAof()
 	}()
+	if handler.aofFsync == FsyncEverySec {
+		go handler.fsyncEverySecond()
+	}
+	go handler.serveAutoRewrite()
 	return handler, nil
 }
 
 // AddAof send command to aof goroutine through channel   加入到缓冲区
+// with appendfsync always, it writes and fsync directly, the caller is blocked until fsync completes
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
+		handler.closeMu.RLock()
+		defer handler.closeMu.RUnlock()
+		if handler.closed {
+			// commands of clients still connected during shutdown are dropped after Close
+			return
+		}
+		p := &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
 		}
+		if handler.aofFsync == FsyncAlways {
+			handler.pausingAof.Lock()
+			if handler.writeAof(p) {
+				handler.fsync()
+			}
+			handler.pausingAof.Unlock()
+			return
+		}
+		handler.aofChan <- p
 	}
 }
 
 // handleAof listen aof channel and write into file   持久化到硬盘
 func (handler *AofHandler) handleAof() {
 	// serialized execution
-	handler.currentDB = 0
 	for p := range handler.aofChan {
-		if p.dbIndex != handler.currentDB {
-			// select db
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue // skip this command
-			}
-			handler.currentDB = p.dbIndex
-		}
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
-		if err != nil {
-			logger.Warn(err)
-		}
+		handler.pausingAof.Lock()
+		handler.writeAof(p)
+		handler.pausingAof.Unlock()
+	}
+	close(handler.aofFinished)
+}
+
+// writeAof writes a command into aof file, caller should hold pausingAof
+// it returns false if nothing is written
+func (handler *AofHandler) writeAof(p *payload) bool {
+	var data []byte
+	if p.dbIndex != handler.currentDB {
+		// select db
+		data = reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
+	}
+	data = append(data, reply.MakeMultiBulkReply(p.cmdLine).ToBytes()...)
+	n, err := handler.aofFile.Write(data)
+	handler.aofSize += int64(n)
+	if err != nil {
+		handler.recordWriteError(err)
+		return false
+	}
+	handler.currentDB = p.dbIndex
+	handler.statsMu.Lock()
+	handler.stats.LastWriteError = nil
+	if handler.unsyncedSince.IsZero() && handler.aofFsync != FsyncNo {
+		handler.unsyncedSince = time.Now()
 	}
+	handler.statsMu.Unlock()
+	return true
+}
+
+func (handler *AofHandler) recordWriteError(err error) {
+	logger.Warn("aof write failed: " + err.Error())
+	handler.statsMu.Lock()
+	handler.stats.WriteErrors++
+	handler.stats.LastWriteError = err
+	handler.statsMu.Unlock()
 }
 
-// LoadAof read aof file
-func (handler *AofHandler) LoadAof() { //加载aof
+// slowFsyncThreshold 超过这个时间的 fsync 会被记录为延迟
+const slowFsyncThreshold = time.Second
+
+// fsync flushes aof file to disk and records the result, caller should hold pausingAof
+func (handler *AofHandler) fsync() {
+	start := time.Now()
+	err := handler.aofFile.Sync()
+	cost := time.Since(start)
 
-	file, err := os.Open(handler.aofFilename)
+	handler.statsMu.Lock()
+	defer handler.statsMu.Unlock()
+	handler.stats.LastFsyncCost = cost
+	if cost > slowFsyncThreshold {
+		handler.stats.DelayedFsyncs++
+		logger.Warn("aof fsync is taking too long (disk is busy?), cost " + cost.String())
+	}
 	if err != nil {
-		logger.Warn(err)
+		logger.Error("aof fsync failed: " + err.Error())
+		handler.stats.FsyncErrors++
+		handler.stats.LastFsyncError = err
 		return
 	}
+	handler.stats.LastFsyncError = nil
+	handler.stats.LastFsyncTime = time.Now()
+	handler.unsyncedSince = time.Time{}
+}
+
+// fsyncEverySecond does fsync in background for appendfsync everysec
+func (handler *AofHandler) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			handler.pausingAof.Lock()
+			handler.fsync()
+			handler.pausingAof.Unlock()
+		case <-handler.stopCh:
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of fsync statistics
+func (handler *AofHandler) Stats() FsyncStats {
+	handler.statsMu.Lock()
+	defer handler.statsMu.Unlock()
+	stats := handler.stats
+	if !handler.unsyncedSince.IsZero() {
+		stats.Lag = time.Since(handler.unsyncedSince)
+	}
+	return stats
+}
+
+// Close gracefully stops aof persistence procedure, pending commands are written and synced
+func (handler *AofHandler) Close() {
+	if handler.aofFile == nil {
+		return
+	}
+	handler.closeMu.Lock()
+	defer handler.closeMu.Unlock()
+	close(handler.aofChan)
+	<-handler.aofFinished // wait for aof finished
+	close(handler.stopCh)
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	handler.closed = true
+	if handler.aofFsync != FsyncNo {
+		handler.fsync()
+	}
+	if err := handler.aofFile.Close(); err != nil {
+		logger.Warn(err)
+	}
+}
+
+// LoadAof replays the base file and incr files listed in manifest in order
+func (handler *AofHandler) LoadAof() error { //加载aof
+	return handler.loadFiles(handler.manifest.files(), true)
+}
+
+// loadFiles replays the given files in order. the last file may be truncated if allowTruncated is true
+func (handler *AofHandler) loadFiles(files []*aofInfo, allowTruncated bool) error {
+	for i, info := range files {
+		filename := filepath.Join(handler.aofDir, info.name)
+		isLast := i == len(files)-1
+		if err := handler.loadFile(filename, allowTruncated && isLast); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile replays a single aof file.
+// If the file begins with a rdb preamble, the snapshot is loaded directly, then the following commands are replayed.
+// An incomplete command at the end of file is truncated if allowTruncated and aof-load-truncated are enabled,
+// other corruption stops loading with an error, use aof-check to find and fix it
+func (handler *AofHandler) loadFile(filename string, allowTruncated bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
-	ch := parser.ParseStream(file)
+	bufReader := bufio.NewReader(file)
+	var preambleSize int64
+	if hasRDBPreamble(bufReader) {
+		// rdb 部分直接加载，之后的命令从同一个 bufReader 继续解析
+		dec := rdb.NewDecoder(bufReader)
+		if err := handler.db.LoadRDB(dec); err != nil {
+			return fmt.Errorf("bad rdb preamble reading %s at offset %d: %v", filename, dec.Offset(), err)
+		}
+		preambleSize = dec.Offset()
+	}
+	ch := parser.ParseStream(bufReader)
+	defer func() {
+		// 提前返回时也要读完管道，否则解析协程会一直阻塞
+		for range ch {
+		}
+	}()
+	// each file begins with db 0
 	fakeConn := &connection.Connection{} // only used for save dbIndex
+	fakeConn.SetAuthenticated(true)      // commands in aof are trusted
+	validOffset := preambleSize          // end of the last valid command
 	for p := range ch {
 		if p.Err != nil {
 			if p.Err == io.EOF {
 				break
 			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
-		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+			if p.Err == io.ErrUnexpectedEOF && allowTruncated {
+				return truncateAof(filename, validOffset)
+			}
+			return fmt.Errorf("bad file format reading %s at offset %d: %v", filename, validOffset, p.Err)
 		}
 		r, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk reply")
-			continue
+		if !ok || len(r.Args) == 0 {
+			return fmt.Errorf("bad file format reading %s at offset %d: require multi bulk reply", filename, validOffset)
 		}
 		ret := handler.db.Exec(fakeConn, r.Args)
 		if reply.IsErrorReply(ret) {
-			logger.Error("exec err", err)
+			logger.Error("exec err: " + string(ret.ToBytes()))
 		}
+		validOffset = preambleSize + p.Offset
 	}
+	return nil
+}
+
+// truncateAof removes the incomplete command at the end of aof file, which is usually left by a crash
+func truncateAof(filename string, validOffset int64) error {
+	if !config.Properties.AofLoadTruncated {
+		return fmt.Errorf("unexpected end of file reading %s at offset %d, "+
+			"set aof-load-truncated yes or use aof-check --fix to truncate it", filename, validOffset)
+	}
+	logger.Warn(fmt.Sprintf("!!! aof file %s is truncated, the incomplete command after offset %d is removed",
+		filename, validOffset))
+	return os.Truncate(filename, validOffset)
+}
+
+// openIncrFile opens the last incr file for appending, a new incr file is created if there is none
+func (handler *AofHandler) openIncrFile() error {
+	m := handler.manifest
+	if len(m.incrs) > 0 {
+		last := m.incrs[len(m.incrs)-1]
+		file, err := os.OpenFile(filepath.Join(handler.aofDir, last.name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		handler.aofFile = file
+		handler.currentDB = -1 // the db selected at the end of file is unknown
+		return nil
+	}
+	file, m, err := handler.createIncrFile()
+	if err != nil {
+		return err
+	}
+	handler.aofFile = file
+	handler.manifest = m
+	handler.currentDB = -1
+	return nil
+}
+
+// createIncrFile creates a new incr file and persists a manifest containing it, the returned manifest is not applied yet
+// 先创建文件再写 manifest，崩溃时最多留下一个没有被引用的空文件
+func (handler *AofHandler) createIncrFile() (*os.File, *manifest, error) {
+	m := handler.manifest.copy()
+	m.currIncrSeq++
+	info := &aofInfo{
+		name:     incrFileName(handler.aofFilename, m.currIncrSeq),
+		seq:      m.currIncrSeq,
+		fileType: aofTypeIncr,
+	}
+	m.incrs = append(m.incrs, info)
+	path := filepath.Join(handler.aofDir, info.name)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := persistManifest(handler.aofDir, handler.aofFilename, m); err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return nil, nil, err
+	}
+	return file, m, nil
+}
+
+// filesSize returns the total size of given files in aof dir
+func (handler *AofHandler) filesSize(files []*aofInfo) int64 {
+	var size int64
+	for _, info := range files {
+		if stat, err := os.Stat(filepath.Join(handler.aofDir, info.name)); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
+}
+
+func aofDirname() string {
+	if config.Properties.AppendDirname == "" {
+		return defaultAofDirname
+	}
+	return config.Properties.AppendDirname
+}
+
+func aofFilename() string {
+	if config.Properties.AppendFilename == "" {
+		return defaultAofFilename
+	}
+	return config.Properties.AppendFilename
+}
+
+// hasRDBPreamble tells whether the aof begins with a rdb snapshot
+func hasRDBPreamble(reader *bufio.Reader) bool {
+	header, err := reader.Peek(len(rdb.Magic))
+	return err == nil && string(header) == rdb.Magic
 }
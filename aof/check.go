@@ -0,0 +1,91 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-redis/rdb"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"io"
+	"os"
+)
+
+// CheckResult is the result of checking an aof file
+type CheckResult struct {
+	Size      int64 // size of the aof file
+	ValidSize int64 // the file is valid up to this offset, it equals to Size if no corruption found
+	Commands  int   // number of valid commands
+	// Err describes the first corruption, it is nil if the file is valid
+	Err error
+	// Truncated is true if the file only ends with an incomplete command, which is usually left by a crash
+	Truncated bool
+	// PreambleSize is the size of rdb preamble, 0 if the file has no preamble
+	PreambleSize int64
+	// BadPreamble is true if the rdb preamble is corrupted, it can not be fixed by truncating
+	BadPreamble bool
+}
+
+// Check validates the aof file without executing commands and reports the offset of the first corruption
+func Check(filename string) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &CheckResult{Size: info.Size()}
+
+	reader := bufio.NewReader(file)
+	if hasRDBPreamble(reader) {
+		dec := rdb.NewDecoder(reader)
+		err := dec.Parse(func(o *rdb.Object) bool {
+			return true
+		})
+		if err != nil {
+			result.Err = fmt.Errorf("bad rdb preamble at offset %d: %v", dec.Offset(), err)
+			result.BadPreamble = true
+			return result, nil
+		}
+		result.PreambleSize = dec.Offset()
+		result.ValidSize = dec.Offset()
+	}
+	ch := parser.ParseStream(reader)
+	defer func() {
+		for range ch {
+		}
+	}()
+	for p := range ch {
+		if p.Err != nil {
+			if p.Err == io.EOF {
+				break
+			}
+			result.Err = p.Err
+			result.Truncated = p.Err == io.ErrUnexpectedEOF
+			return result, nil
+		}
+		r, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok || len(r.Args) == 0 {
+			result.Err = errors.New("require multi bulk reply")
+			return result, nil
+		}
+		result.Commands++
+		result.ValidSize = result.PreambleSize + p.Offset
+	}
+	return result, nil
+}
+
+// Fix truncates the aof file at validSize, the data after it is discarded
+func Fix(filename string, validSize int64) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if validSize < 0 || validSize > info.Size() {
+		return fmt.Errorf("invalid offset %d, file size is %d", validSize, info.Size())
+	}
+	return os.Truncate(filename, validSize)
+}
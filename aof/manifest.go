@@ -0,0 +1,287 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Multi part aof, like redis 7, consists of a base file, incremental files and a manifest listing them:
+//
+//	file appendonly.aof.1.base.rdb seq 1 type b
+//	file appendonly.aof.1.incr.aof seq 1 type i
+//	file appendonly.aof.2.incr.aof seq 2 type i
+//
+// commands are appended to the last incr file. A rewrite opens a new incr file first,
+// then dumps the data of the old files into a new base file, finally the manifest is replaced
+// atomically with the new base and the new incr files, so the aof is complete whenever the process is killed.
+
+const (
+	defaultAofDirname  = "appendonlydir"
+	defaultAofFilename = "appendonly.aof"
+
+	aofTypeBase = "b"
+	aofTypeIncr = "i"
+
+	manifestSuffix = ".manifest"
+	baseSuffix     = ".base"
+	incrSuffix     = ".incr"
+	aofExt         = ".aof"
+	rdbExt         = ".rdb"
+	// tempFilePrefix is the prefix of temp files, they are removed at startup
+	tempFilePrefix = "temp-"
+)
+
+// aofInfo is a file entry of manifest
+type aofInfo struct {
+	name     string
+	seq      int64
+	fileType string
+}
+
+// manifest lists files of multi part aof, it is never modified after persisted, make a copy to change it
+type manifest struct {
+	base        *aofInfo // nil if there is no base file
+	incrs       []*aofInfo
+	currBaseSeq int64
+	currIncrSeq int64
+}
+
+// files returns base and incr files in loading order
+func (m *manifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *manifest) copy() *manifest {
+	incrs := make([]*aofInfo, len(m.incrs))
+	copy(incrs, m.incrs)
+	return &manifest{
+		base:        m.base,
+		incrs:       incrs,
+		currBaseSeq: m.currBaseSeq,
+		currIncrSeq: m.currIncrSeq,
+	}
+}
+
+func (m *manifest) marshal() []byte {
+	var buf bytes.Buffer
+	for _, info := range m.files() {
+		fmt.Fprintf(&buf, "file %s seq %d type %s\n", info.name, info.seq, info.fileType)
+	}
+	return buf.Bytes()
+}
+
+func parseManifest(data []byte) (*manifest, error) {
+	m := &manifest{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNo, line)
+		}
+		info := &aofInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNo, line)
+				}
+				info.seq = seq
+			case "type":
+				info.fileType = fields[i+1]
+			}
+			// unknown fields are ignored for forward compatibility
+		}
+		if info.name == "" || strings.ContainsAny(info.name, `/\`) {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNo, line)
+		}
+		switch info.fileType {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, errors.New("invalid aof manifest: more than one base file")
+			}
+			m.base = info
+			m.currBaseSeq = info.seq
+		case aofTypeIncr:
+			m.incrs = append(m.incrs, info)
+			if info.seq > m.currIncrSeq {
+				m.currIncrSeq = info.seq
+			}
+		default:
+			// history files (type h) are deleted already, skip them
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func manifestPath(dir string, filename string) string {
+	return filepath.Join(dir, filename+manifestSuffix)
+}
+
+// loadManifest reads manifest in dir, it returns nil if the manifest does not exist
+func loadManifest(dir string, filename string) (*manifest, error) {
+	data, err := os.ReadFile(manifestPath(dir, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// persistManifest writes manifest into a temp file and renames it, so the manifest is replaced atomically
+func persistManifest(dir string, filename string, m *manifest) (err error) {
+	file, err := os.CreateTemp(dir, tempFilePrefix+"*"+manifestSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if _, err = file.Write(m.marshal()); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(file.Name(), manifestPath(dir, filename)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renaming in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func baseFileName(filename string, seq int64, preamble bool) string {
+	ext := aofExt
+	if preamble {
+		ext = rdbExt
+	}
+	return filename + "." + strconv.FormatInt(seq, 10) + baseSuffix + ext
+}
+
+func incrFileName(filename string, seq int64) string {
+	return filename + "." + strconv.FormatInt(seq, 10) + incrSuffix + aofExt
+}
+
+// ManifestFiles returns paths of files listed in the manifest in loading order
+func ManifestFiles(manifestFile string) ([]string, error) {
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(manifestFile)
+	var paths []string
+	for _, info := range m.files() {
+		paths = append(paths, filepath.Join(dir, info.name))
+	}
+	return paths, nil
+}
+
+// openManifest loads manifest of aof in dir, a single file aof of old versions is moved into dir as the base file
+func openManifest(dir string, filename string) (*manifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir, filename)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		return m, nil
+	}
+
+	// 旧版本的单文件 aof：先移动到目录中，再写 manifest。
+	// 如果在两步之间崩溃，下次启动时目录中的同名文件仍会被当作 base
+	m = &manifest{}
+	inDir := filepath.Join(dir, filename)
+	if _, err := os.Stat(filename); err == nil {
+		if _, err := os.Stat(inDir); err == nil {
+			return nil, fmt.Errorf("both %s and %s exist, remove one of them", filename, inDir)
+		}
+		if err := os.Rename(filename, inDir); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(inDir); err == nil {
+		m.base = &aofInfo{name: filename, seq: 1, fileType: aofTypeBase}
+		m.currBaseSeq = 1
+		if err := persistManifest(dir, filename, m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// removeUnreferenced removes temp files and aof files not listed in manifest,
+// they are left by a rewrite which is interrupted or whose old files are not deleted
+func removeUnreferenced(dir string, filename string, m *manifest) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	referenced := make(map[string]bool)
+	for _, info := range m.files() {
+		referenced[info.name] = true
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || referenced[name] {
+			continue
+		}
+		isAofFile := strings.HasPrefix(name, filename+".") &&
+			(strings.Contains(name, baseSuffix+".") || strings.HasSuffix(name, incrSuffix+aofExt))
+		if strings.HasPrefix(name, tempFilePrefix) || isAofFile {
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+// Exists tells whether there is aof data to load, in the aof dir or as a single file of old versions
+func Exists() bool {
+	dir, filename := aofDirname(), aofFilename()
+	for _, path := range []string{manifestPath(dir, filename), filename, filepath.Join(dir, filename)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
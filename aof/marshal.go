@@ -0,0 +1,184 @@
+package aof
+
+import (
+	"go-redis/datastruct/dict"
+	List "go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	SortedSet "go-redis/datastruct/sortedset"
+	"go-redis/datastruct/stream"
+	"go-redis/interface/database"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"strconv"
+	"time"
+)
+
+// MakeExpireCmd generates command line to set expiration for the given key
+// 过期时间统一以绝对时间 PEXPIREAT 写入 aof，这样重放后得到的过期时刻和原来一致
+func MakeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return utils.ToCmdLine("PEXPIREAT", key, strconv.FormatInt(expireAt.UnixNano()/1e6, 10))
+}
+
+// EntityToCmds serialize data entity to redis commands, it returns nil for unknown type
+// most types need only one command, but a stream is rebuilt entry by entry to keep the ids
+func EntityToCmds(key string, entity *database.DataEntity) []CmdLine {
+	if entity == nil {
+		return nil
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return []CmdLine{stringToCmd(key, val)}
+	case List.List:
+		return []CmdLine{listToCmd(key, val)}
+	case dict.Dict:
+		return []CmdLine{hashToCmd(key, val)}
+	case *set.Set:
+		return []CmdLine{setToCmd(key, val)}
+	case *SortedSet.SortedSet:
+		return []CmdLine{zSetToCmd(key, val)}
+	case *stream.Stream:
+		return streamToCmds(key, val)
+	}
+	return nil
+}
+
+func stringToCmd(key string, bytes []byte) CmdLine {
+	return [][]byte{[]byte("SET"), []byte(key), bytes}
+}
+
+func listToCmd(key string, list List.List) CmdLine {
+	args := make([][]byte, 2, 2+list.Len())
+	args[0] = []byte("RPUSH")
+	args[1] = []byte(key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, bytes)
+		return true
+	})
+	return args
+}
+
+func setToCmd(key string, set *set.Set) CmdLine {
+	args := make([][]byte, 2, 2+set.Len())
+	args[0] = []byte("SADD")
+	args[1] = []byte(key)
+	set.ForEach(func(val string) bool {
+		args = append(args, []byte(val))
+		return true
+	})
+	return args
+}
+
+func hashToCmd(key string, hash dict.Dict) CmdLine {
+	args := make([][]byte, 2, 2+hash.Len()*2)
+	args[0] = []byte("HSET")
+	args[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, []byte(field), bytes)
+		return true
+	})
+	return args
+}
+
+func zSetToCmd(key string, zset *SortedSet.SortedSet) CmdLine {
+	args := make([][]byte, 2, 2+zset.Len()*2)
+	args[0] = []byte("ZADD")
+	args[1] = []byte(key)
+	zset.ForEachByRank(0, zset.Len(), false, func(element *SortedSet.Element) bool {
+		// 'g' 格式保证精度不丢失，inf/-inf 写作 +Inf/-Inf 同样可以被 ParseFloat 解析
+		value := strconv.FormatFloat(element.Score, 'g', -1, 64)
+		args = append(args, []byte(value), []byte(element.Member))
+		return true
+	})
+	return args
+}
+
+func streamToCmds(key string, s *stream.Stream) []CmdLine {
+	lastID := s.LastID().String()
+	if s.Len() == 0 {
+		// 空 stream 也要保留 key 和 last id：添加一个 entry 后立即裁剪掉
+		return []CmdLine{utils.ToCmdLine("XADD", key, "MAXLEN", "0", lastID, "x", "y")}
+	}
+	cmds := make([]CmdLine, 0, s.Len()+1)
+	s.ForEach(func(entry *stream.Entry) bool {
+		cmd := make([][]byte, 3, 3+len(entry.Fields))
+		cmd[0] = []byte("XADD")
+		cmd[1] = []byte(key)
+		cmd[2] = []byte(entry.ID.String())
+		cmds = append(cmds, append(cmd, entry.Fields...))
+		return true
+	})
+	if lastEntryID, _ := s.LastEntryID(); lastEntryID != s.LastID() {
+		// the last entries were deleted, later generated ids must still be greater than them
+		cmds = append(cmds, utils.ToCmdLine("XSETID", key, lastID))
+	}
+	return cmds
+}
+
+// EntityToObject converts data entity into rdb object, it returns nil for unknown type.
+// values are copied, so the object can be encoded after the lock of key is released
+func EntityToObject(key string, entity *database.DataEntity) *rdb.Object {
+	if entity == nil {
+		return nil
+	}
+	obj := &rdb.Object{Key: key}
+	switch val := entity.Data.(type) {
+	case []byte:
+		// SETRANGE 会原地修改字节数组，这里必须复制
+		obj.Type = rdb.StringType
+		obj.Value = append([]byte(nil), val...)
+	case List.List:
+		values := make([][]byte, 0, val.Len())
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			values = append(values, bytes)
+			return true
+		})
+		obj.Type = rdb.ListType
+		obj.Value = values
+	case dict.Dict:
+		hash := make(map[string][]byte, val.Len())
+		val.ForEach(func(field string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			hash[field] = bytes
+			return true
+		})
+		obj.Type = rdb.HashType
+		obj.Value = hash
+	case *set.Set:
+		members := make([][]byte, 0, val.Len())
+		val.ForEach(func(member string) bool {
+			members = append(members, []byte(member))
+			return true
+		})
+		obj.Type = rdb.SetType
+		obj.Value = members
+	case *SortedSet.SortedSet:
+		entries := make([]*rdb.ZSetEntry, 0, val.Len())
+		val.ForEachByRank(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			entries = append(entries, &rdb.ZSetEntry{Member: element.Member, Score: element.Score})
+			return true
+		})
+		obj.Type = rdb.ZSetType
+		obj.Value = entries
+	case *stream.Stream:
+		// entry 添加后不会被修改，只需复制 entry 列表
+		value := &rdb.StreamValue{
+			Entries: make([]*rdb.StreamEntry, 0, val.Len()),
+			LastID:  rdb.StreamID{Ms: val.LastID().Ms, Seq: val.LastID().Seq},
+		}
+		val.ForEach(func(entry *stream.Entry) bool {
+			value.Entries = append(value.Entries, &rdb.StreamEntry{
+				ID:     rdb.StreamID{Ms: entry.ID.Ms, Seq: entry.ID.Seq},
+				Fields: entry.Fields,
+			})
+			return true
+		})
+		obj.Type = rdb.StreamType
+		obj.Value = value
+	default:
+		return nil
+	}
+	return obj
+}
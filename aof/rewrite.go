@@ -0,0 +1,309 @@
+package aof
+
+import (
+	"errors"
+	"go-redis/config"
+	"go-redis/interface/database"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrRewriteInProgress is returned if a rewrite is already running
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// RewriteCtx holds context of an AOF rewriting procedure
+type RewriteCtx struct {
+	tmpFile *os.File // tmpFile is the file handler of the new base file
+	// files are the base and incr files before rewrite, they are replayed to generate the new base
+	files []*aofInfo
+	// incrCount is the number of incr files in files, they are removed from manifest after rewrite
+	incrCount int
+}
+
+// Rewrite compacts aof into a new base file, it blocks until finished.
+// 重写开始时切换到新的 incr 文件，旧文件不再写入；重写完成后用新 base 和新 incr 替换 manifest
+func (handler *AofHandler) Rewrite() error {
+	if !handler.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	defer handler.rewriting.Set(false)
+
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		logger.Warn("aof rewrite failed: " + err.Error())
+		return err
+	}
+	err = handler.doRewrite(ctx)
+	if err != nil {
+		discardRewrite(ctx)
+		logger.Warn("aof rewrite failed: " + err.Error())
+		return err
+	}
+	err = handler.finishRewrite(ctx)
+	if err != nil {
+		logger.Warn("aof rewrite failed: " + err.Error())
+		return err
+	}
+	logger.Info("aof rewrite finished")
+	return nil
+}
+
+// IsRewriting tells whether a rewrite is in progress
+func (handler *AofHandler) IsRewriting() bool {
+	return handler.rewriting.Get()
+}
+
+// startRewrite switches writing to a new incr file, so the files before it are never modified and can be replayed safely
+func (handler *AofHandler) startRewrite() (*RewriteCtx, error) {
+	handler.pausingAof.Lock() // pausing aof
+	defer handler.pausingAof.Unlock()
+	if handler.closed {
+		return nil, errors.New("aof is closed")
+	}
+
+	// the old incr file must be complete on disk before it is replayed
+	handler.fsync()
+	files := handler.manifest.files()
+	incrCount := len(handler.manifest.incrs)
+	file, m, err := handler.createIncrFile()
+	if err != nil {
+		return nil, err
+	}
+	_ = handler.aofFile.Close()
+	handler.aofFile = file
+	handler.manifest = m
+	handler.currentDB = -1 // new file begins with db 0, select db before the first command
+
+	tmpFile, err := os.CreateTemp(handler.aofDir, tempFilePrefix+"rewriteaof-*"+aofExt)
+	if err != nil {
+		return nil, err
+	}
+	return &RewriteCtx{
+		tmpFile:   tmpFile,
+		files:     files,
+		incrCount: incrCount,
+	}, nil
+}
+
+// doRewrite replays the old files into a temporary db and dumps it into the tmp file, it doesn't block aof writing
+func (handler *AofHandler) doRewrite(ctx *RewriteCtx) error {
+	tmpFile := ctx.tmpFile
+
+	// load aof tmpFile
+	tmpDB := handler.tmpDBMaker()
+	defer tmpDB.Close()
+	tmpAof := &AofHandler{
+		db:     tmpDB,
+		aofDir: handler.aofDir,
+	}
+	if err := tmpAof.loadFiles(ctx.files, false); err != nil {
+		return err
+	}
+
+	if config.Properties.AofUseRdbPreamble {
+		return writeRDBPreamble(tmpFile, tmpDB)
+	}
+
+	// rewrite aof tmpFile
+	now := time.Now()
+	for i := 0; i < config.Properties.Databases; i++ {
+		var err error
+		selected := false
+		tmpDB.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if expiration != nil && !expiration.After(now) {
+				return true // already expired
+			}
+			cmds := EntityToCmds(key, entity)
+			if cmds == nil {
+				return true
+			}
+			if !selected {
+				// select db
+				data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
+				if _, err = tmpFile.Write(data); err != nil {
+					return false
+				}
+				selected = true
+			}
+			for _, cmd := range cmds {
+				if _, err = tmpFile.Write(reply.MakeMultiBulkReply(cmd).ToBytes()); err != nil {
+					return false
+				}
+			}
+			if expiration != nil {
+				cmd := MakeExpireCmd(key, *expiration)
+				if _, err = tmpFile.Write(reply.MakeMultiBulkReply(cmd).ToBytes()); err != nil {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRDBPreamble dumps the db in rdb format, commands written during rewriting are appended after it
+func writeRDBPreamble(w io.Writer, db database.DBEngine) error {
+	enc := rdb.NewEncoder(w)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	auxs := [][2]string{
+		{"redis-ver", rdb.RedisVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"aof-preamble", "1"},
+	}
+	for _, aux := range auxs {
+		if err := enc.WriteAux(aux[0], aux[1]); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	for i := 0; i < config.Properties.Databases; i++ {
+		keyCount, ttlCount := 0, 0
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			keyCount++
+			if expiration != nil {
+				ttlCount++
+			}
+			return true
+		})
+		if keyCount == 0 {
+			continue
+		}
+		if err := enc.WriteDBHeader(i, keyCount, ttlCount); err != nil {
+			return err
+		}
+		var err error
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if expiration != nil && !expiration.After(now) {
+				return true // already expired
+			}
+			obj := EntityToObject(key, entity)
+			if obj == nil {
+				return true
+			}
+			obj.DB = i
+			obj.Expiration = expiration
+			err = enc.WriteObject(obj)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return enc.WriteEnd()
+}
+
+// afterBaseRename is called after the new base file is renamed and before the manifest is replaced,
+// tests use it to inspect files on disk at this moment, as if the process crashed here
+var afterBaseRename = func() {}
+
+// finishRewrite renames the tmp file as the new base and replaces manifest, then removes the old files
+func (handler *AofHandler) finishRewrite(ctx *RewriteCtx) error {
+	tmpFile := ctx.tmpFile
+	if err := tmpFile.Sync(); err != nil {
+		discardRewrite(ctx)
+		return err
+	}
+	info, err := tmpFile.Stat()
+	if err != nil {
+		discardRewrite(ctx)
+		return err
+	}
+	_ = tmpFile.Close()
+
+	handler.pausingAof.Lock() // pausing aof
+	defer handler.pausingAof.Unlock()
+	if handler.closed {
+		_ = os.Remove(tmpFile.Name())
+		return errors.New("aof is closed")
+	}
+
+	m := handler.manifest.copy()
+	m.currBaseSeq++
+	base := &aofInfo{
+		name:     baseFileName(handler.aofFilename, m.currBaseSeq, config.Properties.AofUseRdbPreamble),
+		seq:      m.currBaseSeq,
+		fileType: aofTypeBase,
+	}
+	basePath := filepath.Join(handler.aofDir, base.name)
+	if err := os.Rename(tmpFile.Name(), basePath); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	afterBaseRename()
+	m.base = base
+	// incr files created after rewrite started are kept
+	m.incrs = m.incrs[ctx.incrCount:]
+	// manifest 的替换是原子的：崩溃时要么是旧的 base 和全部 incr，要么是新的 base 和新的 incr
+	if err := persistManifest(handler.aofDir, handler.aofFilename, m); err != nil {
+		_ = os.Remove(basePath)
+		return err
+	}
+	handler.manifest = m
+	for _, old := range ctx.files {
+		if err := os.Remove(filepath.Join(handler.aofDir, old.name)); err != nil {
+			logger.Warn("remove old aof file failed: " + err.Error())
+		}
+	}
+	handler.baseSize = info.Size()
+	handler.aofSize = info.Size() + handler.filesSize(m.incrs)
+	return nil
+}
+
+// discardRewrite removes the tmp file, the new incr file is kept in manifest
+func discardRewrite(ctx *RewriteCtx) {
+	_ = ctx.tmpFile.Close()
+	_ = os.Remove(ctx.tmpFile.Name())
+}
+
+// autoRewriteCheckInterval is the interval of checking aof size for auto rewrite
+const autoRewriteCheckInterval = time.Second
+
+// serveAutoRewrite starts rewrite if aof file grows larger than auto-aof-rewrite-min-size
+// and its growth since the last rewrite exceeds auto-aof-rewrite-percentage
+func (handler *AofHandler) serveAutoRewrite() {
+	ticker := time.NewTicker(autoRewriteCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if handler.needRewrite() {
+				logger.Info("starting automatic rewriting of AOF")
+				_ = handler.Rewrite()
+			}
+		case <-handler.stopCh:
+			return
+		}
+	}
+}
+
+func (handler *AofHandler) needRewrite() bool {
+	percentage := int64(config.Properties.AutoAofRewritePercentage)
+	if percentage <= 0 || handler.rewriting.Get() {
+		return false
+	}
+	handler.pausingAof.Lock()
+	size, base := handler.aofSize, handler.baseSize
+	handler.pausingAof.Unlock()
+	if size < config.Properties.AutoAofRewriteMinSize {
+		return false
+	}
+	if base <= 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	return growth >= percentage
+}
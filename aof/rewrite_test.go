@@ -0,0 +1,178 @@
+package aof
+
+import (
+	"errors"
+	"go-redis/config"
+	databaseface "go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memDB is a tiny DBEngine which supports SELECT and SET only
+type memDB struct {
+	mu   sync.Mutex
+	data map[int]map[string][]byte
+}
+
+func newMemDB() databaseface.DBEngine {
+	return &memDB{data: make(map[int]map[string][]byte)}
+}
+
+func (db *memDB) Exec(c resp.Connection, args [][]byte) resp.Reply {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch strings.ToLower(string(args[0])) {
+	case "select":
+		index, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR invalid DB index")
+		}
+		c.SelectDB(index)
+	case "set":
+		index := c.GetDBIndex()
+		if db.data[index] == nil {
+			db.data[index] = make(map[string][]byte)
+		}
+		db.data[index][string(args[1])] = args[2]
+	default:
+		return reply.MakeErrReply("ERR unknown command '" + string(args[0]) + "'")
+	}
+	return &reply.OkReply{}
+}
+
+func (db *memDB) AfterClientClose(c resp.Connection) {}
+
+func (db *memDB) Close() {}
+
+func (db *memDB) ForEach(dbIndex int, cb func(key string, data *databaseface.DataEntity, expiration *time.Time) bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for key, val := range db.data[dbIndex] {
+		if !cb(key, &databaseface.DataEntity{Data: val}, nil) {
+			return
+		}
+	}
+}
+
+func (db *memDB) LoadRDB(dec *rdb.Decoder) error {
+	return errors.New("rdb preamble is not supported")
+}
+
+func setupAofConfig(dir string) (restore func()) {
+	old := config.Properties
+	config.Properties = &config.ServerProperties{
+		AppendOnly:    true,
+		AppendDirname: dir,
+		AppendFsync:   FsyncAlways,
+		Databases:     16,
+	}
+	return func() {
+		config.Properties = old
+	}
+}
+
+// copyDir copies files in src to dst, as if the process crashed and dst is what left on disk
+func copyDir(t *testing.T, src string, dst string) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// reload opens aof in dir like a restarted server and returns the loaded data
+func reload(t *testing.T, dir string) map[int]map[string][]byte {
+	config.Properties.AppendDirname = dir
+	db := newMemDB()
+	handler, err := NewAOFHandler(db, newMemDB)
+	if err != nil {
+		t.Fatalf("reload %s: %v", dir, err)
+	}
+	handler.Close()
+	return db.(*memDB).data
+}
+
+func checkData(t *testing.T, data map[int]map[string][]byte, expected map[int]map[string]string) {
+	for index, kvs := range expected {
+		if len(data[index]) != len(kvs) {
+			t.Errorf("db %d: expected %d keys, actual %d", index, len(kvs), len(data[index]))
+		}
+		for key, val := range kvs {
+			if actual := string(data[index][key]); actual != val {
+				t.Errorf("db %d: expected %s=%s, actual %q", index, key, val, actual)
+			}
+		}
+	}
+}
+
+func TestRewriteInterruptedBeforeManifest(t *testing.T) {
+	dir := t.TempDir()
+	crashDir := t.TempDir()
+	defer setupAofConfig(dir)()
+
+	handler, err := NewAOFHandler(newMemDB(), newMemDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	handler.AddAof(1, utils.ToCmdLine("set", "b", "2"))
+	// the first rewrite makes a base file, so the interrupted rewrite replaces an existing base
+	if err := handler.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "c", "3"))
+
+	afterBaseRename = func() {
+		copyDir(t, handler.aofDir, crashDir)
+	}
+	defer func() {
+		afterBaseRename = func() {}
+	}()
+	if err := handler.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(1, utils.ToCmdLine("set", "d", "4"))
+	handler.Close()
+
+	// the new base is renamed but the manifest still lists the old base and incr files
+	checkData(t, reload(t, crashDir), map[int]map[string]string{
+		0: {"a": "1", "c": "3"},
+		1: {"b": "2"},
+	})
+	// the new base not referenced by manifest is removed at startup
+	m, err := loadManifest(crashDir, defaultAofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(crashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), baseSuffix+".") && entry.Name() != m.base.name {
+			t.Errorf("unreferenced base file %s is not removed", entry.Name())
+		}
+	}
+
+	checkData(t, reload(t, dir), map[int]map[string]string{
+		0: {"a": "1", "c": "3"},
+		1: {"b": "2", "d": "4"},
+	})
+}
@@ -0,0 +1,7 @@
+package cluster
+
+import "go-redis/interface/resp"
+
+func execACL(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply {
+	return cluster.db.Exec(c, cmdAndArgs)
+}
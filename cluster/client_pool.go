@@ -2,9 +2,14 @@ package cluster
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/jolestar/go-commons-pool/v2"
+	"go-redis/config"
+	"go-redis/lib/tlsutil"
 	"go-redis/resp/client"
+	"go-redis/resp/reply"
+	"time"
 )
 
 // 使用pool时，你需要告诉我 我怎么创建一个连接，怎么摧毁一个连接，也就是要实现一个接口PooledObjectFactory
@@ -15,11 +20,35 @@ type connectionFactory struct {
 
 // MakeObject 创建一个连接
 func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
-	c, err := client.MakeClient(f.Peer)
+	var tlsConfig *tls.Config
+	if config.Properties.TlsCluster {
+		conf, err := tlsutil.ClientConfig(config.Properties.TlsCertFile, config.Properties.TlsKeyFile,
+			config.Properties.TlsCaCertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = conf
+	}
+	c, err := client.MakeTLSClient(f.Peer, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
+	if config.Properties.Timeout > 0 {
+		// 心跳间隔小于对端的空闲超时，避免池中的连接被关闭
+		interval := time.Duration(config.Properties.Timeout) * time.Second / 2
+		if interval < client.DefaultHeartbeatInterval {
+			c.SetHeartbeatInterval(interval)
+		}
+	}
 	c.Start()
+	if config.Properties.RequirePass != "" {
+		// 兄弟节点与本节点使用相同的密码
+		ret := c.Auth(config.Properties.RequirePass)
+		if reply.IsErrorReply(ret) {
+			c.Close()
+			return nil, errors.New("auth failed: " + string(ret.ToBytes()))
+		}
+	}
 	return pool.NewPooledObject(c), nil
 }
 
@@ -11,6 +11,7 @@ import (
 	"go-redis/interface/resp"
 	"go-redis/lib/consistenthash"
 	"go-redis/lib/logger"
+	"go-redis/pubsub"
 	"go-redis/resp/reply"
 	"runtime/debug"
 	"strings"
@@ -76,6 +77,19 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 		}
 	}()
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "auth" {
+		return database.Auth(c, cmdLine[1:])
+	}
+	if !database.IsAuthenticated(c) {
+		return database.MakeNoAuthErrReply()
+	}
+	// commands relayed to peers are executed by the peer connection, so check permission before routing
+	if errReply := database.CheckPermission(c, cmdLine); errReply != nil {
+		return errReply
+	}
+	if errReply := pubsub.CheckSubscribeMode(c, cmdName); errReply != nil {
+		return errReply
+	}
 	cmdFunc, ok := router[cmdName]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
@@ -8,6 +8,7 @@ import (
 	"go-redis/resp/client"
 	"go-redis/resp/reply"
 	"strconv"
+	"strings"
 )
 
 // 负责和其他兄弟节点通信
@@ -45,6 +46,9 @@ func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client
 // 转发命令给相应的节点
 func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]byte) resp.Reply {
 	if peer == cluster.self { // 是自己的连接的任务
+		if cmdFunc, ok := internalCommands[strings.ToLower(string(args[0]))]; ok {
+			return cmdFunc(cluster, c, args)
+		}
 		// to self db
 		return cluster.db.Exec(c, args)
 	}
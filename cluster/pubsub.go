@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"go-redis/acl"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/reply"
+)
+
+// relayPublish is the internal command sending PUBLISH to nodes, receivers deliver it to local subscribers without relaying again
+const relayPublish = "publish_"
+
+func execPubSub(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply {
+	return cluster.db.Exec(c, cmdAndArgs)
+}
+
+// Publish broadcasts message to subscribers on all nodes, returns the number of receivers in the cluster
+// publish channel message
+func Publish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	relayArgs := make([][]byte, len(args))
+	copy(relayArgs, args)
+	relayArgs[0] = []byte(relayPublish)
+	var count int64
+	for node, r := range cluster.broadcast(c, relayArgs) {
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			// 某个节点不可用时仍然投递给其他节点
+			logger.Warn("publish to " + node + " failed: " + string(r.ToBytes()))
+			continue
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// onRelayPublish delivers message relayed by Publish to local subscribers
+func onRelayPublish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdLine := make([][]byte, len(args))
+	copy(cmdLine, args)
+	cmdLine[0] = []byte("publish")
+	return cluster.db.Exec(c, cmdLine)
+}
+
+func init() {
+	// clients may send the internal command too, it needs the same permission as PUBLISH
+	acl.RegisterCommand(relayPublish, acl.CatPubSub)
+}
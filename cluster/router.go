@@ -15,17 +15,121 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["type"] = defaultFunc
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["persist"] = defaultFunc
 
 	routerMap["set"] = defaultFunc
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["setex"] = defaultFunc
+	routerMap["psetex"] = defaultFunc
+	routerMap["getex"] = defaultFunc
+	routerMap["getdel"] = defaultFunc
+
+	routerMap["lpush"] = defaultFunc
+	routerMap["lpushx"] = defaultFunc
+	routerMap["rpush"] = defaultFunc
+	routerMap["rpushx"] = defaultFunc
+	routerMap["lpop"] = defaultFunc
+	routerMap["rpop"] = defaultFunc
+	routerMap["llen"] = defaultFunc
+	routerMap["lindex"] = defaultFunc
+	routerMap["lset"] = defaultFunc
+	routerMap["lrange"] = defaultFunc
+	routerMap["lrem"] = defaultFunc
+	routerMap["ltrim"] = defaultFunc
+	routerMap["linsert"] = defaultFunc
+
+	routerMap["hset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
+	routerMap["hscan"] = defaultFunc
+
+	routerMap["sadd"] = defaultFunc
+	routerMap["sismember"] = defaultFunc
+	routerMap["smismember"] = defaultFunc
+	routerMap["srem"] = defaultFunc
+	routerMap["spop"] = defaultFunc
+	routerMap["scard"] = defaultFunc
+	routerMap["smembers"] = defaultFunc
+	routerMap["srandmember"] = defaultFunc
+	routerMap["sscan"] = defaultFunc
+
+	routerMap["zadd"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zmscore"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zlexcount"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zrangebylex"] = defaultFunc
+	routerMap["zrevrangebylex"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zremrangebyscore"] = defaultFunc
+	routerMap["zremrangebylex"] = defaultFunc
+	routerMap["zremrangebyrank"] = defaultFunc
+	routerMap["zpopmin"] = defaultFunc
+	routerMap["zpopmax"] = defaultFunc
+	routerMap["zscan"] = defaultFunc
+
+	// XREAD may read multiple streams, it is not routed like other blocking commands
+	routerMap["xadd"] = defaultFunc
+	routerMap["xtrim"] = defaultFunc
+	routerMap["xdel"] = defaultFunc
+	routerMap["xsetid"] = defaultFunc
+	routerMap["xlen"] = defaultFunc
+	routerMap["xrange"] = defaultFunc
+	routerMap["xrevrange"] = defaultFunc
 
 	routerMap["flushdb"] = FlushDB
 
+	// users are not synchronized between nodes
+	routerMap["acl"] = execACL
+
+	// subscribers are held by the node they connect to
+	routerMap["subscribe"] = execPubSub
+	routerMap["psubscribe"] = execPubSub
+	routerMap["unsubscribe"] = execPubSub
+	routerMap["punsubscribe"] = execPubSub
+	routerMap["publish"] = Publish
+	routerMap["pubsub"] = execPubSub
+
+	for name, cmdFunc := range internalCommands {
+		routerMap[name] = cmdFunc
+	}
+
 	return routerMap
 }
 
+// internalCommands are sent between nodes, relay executes them by cluster instead of the local db when the node is self
+var internalCommands = map[string]CmdFunc{
+	relayPublish: onRelayPublish,
+}
+
 // relay command to responsible peer, and return its reply to client
 func defaultFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
 	key := string(args[1])
@@ -0,0 +1,112 @@
+// aof-check validates an append only file, reports the byte offset of the first corruption
+// and truncates the file there if --fix is given, like redis-check-aof.
+// For multi part aof, pass the manifest file or the aof dir, files listed in manifest are checked in order,
+// only the last one can be fixed.
+//
+// usage: aof-check [--fix] <file.aof|file.manifest|dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-redis/aof"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const manifestSuffix = ".manifest"
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file at the first corruption")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--fix] <file.aof|file.manifest|dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := listFiles(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot check aof: "+err.Error())
+		os.Exit(1)
+	}
+	for i, filename := range files {
+		isLast := i == len(files)-1
+		if !checkFile(filename, *fix, isLast) {
+			os.Exit(1)
+		}
+	}
+}
+
+// listFiles returns the files to check, the path may be an aof file, a manifest or a dir containing manifest
+func listFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		manifests, err := filepath.Glob(filepath.Join(path, "*"+manifestSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if len(manifests) != 1 {
+			return nil, fmt.Errorf("expect exactly one manifest in %s, found %d", path, len(manifests))
+		}
+		path = manifests[0]
+	}
+	if strings.HasSuffix(path, manifestSuffix) {
+		fmt.Printf("Checking manifest %s\n", path)
+		return aof.ManifestFiles(path)
+	}
+	return []string{path}, nil
+}
+
+// checkFile checks a single file, it returns false if the file is still invalid
+func checkFile(filename string, fix bool, isLast bool) bool {
+	fmt.Printf("Checking %s\n", filename)
+	result, err := aof.Check(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot check aof: "+err.Error())
+		return false
+	}
+	if result.PreambleSize > 0 {
+		fmt.Printf("RDB preamble is valid, %d bytes\n", result.PreambleSize)
+	}
+	if result.Err == nil {
+		fmt.Printf("AOF is valid, %d commands, %d bytes\n", result.Commands, result.Size)
+		return true
+	}
+	if result.BadPreamble {
+		fmt.Printf("AOF is corrupted: %v\n", result.Err)
+		fmt.Println("Corruption in the rdb preamble can not be fixed by aof-check.")
+		return false
+	}
+
+	if result.Truncated {
+		fmt.Printf("AOF ends with an incomplete command at offset %d\n", result.ValidSize)
+	} else {
+		fmt.Printf("AOF is corrupted at offset %d: %v\n", result.ValidSize, result.Err)
+	}
+	diff := result.Size - result.ValidSize
+	fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, ok_commands=%d, diff=%d\n",
+		result.Size, result.ValidSize, result.Commands, diff)
+	if !isLast {
+		// 截断中间的文件会丢失后续文件依赖的数据
+		fmt.Println("Only the last file of multi part aof can be fixed by truncating.")
+		return false
+	}
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		return false
+	}
+	if err := aof.Fix(filename, result.ValidSize); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to fix aof: "+err.Error())
+		return false
+	}
+	fmt.Printf("Successfully truncated AOF, %d bytes are discarded\n", diff)
+	return true
+}
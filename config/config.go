@@ -16,10 +16,39 @@ type ServerProperties struct {
     Port           int    `cfg:"port"`
     AppendOnly     bool   `cfg:"appendOnly"`
     AppendFilename string `cfg:"appendFilename"`
-    MaxClients     int    `cfg:"maxclients"`
+    AppendDirname  string `cfg:"appenddirname"` // dir of multi part aof files and their manifest
+    AppendFsync    string `cfg:"appendfsync"` // always, everysec or no
+    MaxClients     int    `cfg:"maxclients"` // 0 means no limit
+    Timeout        int    `cfg:"timeout"` // close clients idle for more than N seconds, 0 means never
     RequirePass    string `cfg:"requirepass"`
+    AclFile        string `cfg:"aclfile"` // users loaded at startup, the default user uses requirepass unless defined in it
     Databases      int    `cfg:"databases"`
 
+    // classes of keyspace events published, e.g. "KEA" for all, empty string disables them
+    NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+
+    // rewrite aof automatically when it grows by the given percentage since the last rewrite, 0 means disabled
+    AutoAofRewritePercentage int   `cfg:"auto-aof-rewrite-percentage"`
+    AutoAofRewriteMinSize    int64 `cfg:"auto-aof-rewrite-min-size"` // in bytes, units like 64mb are allowed in config file
+    // load an aof file whose last command is incomplete by truncating it, otherwise refuse to start
+    AofLoadTruncated         bool  `cfg:"aof-load-truncated"`
+    // rewrite aof as a rdb snapshot followed by commands, which loads much faster
+    AofUseRdbPreamble        bool  `cfg:"aof-use-rdb-preamble"`
+
+    // save policies like "900 1 300 10", which means save after 900 sec if at least 1 key changed
+    // or after 300 sec if at least 10 keys changed. repeated `save` lines are concatenated
+    Save        string `cfg:"save"`
+    RDBFilename string `cfg:"dbfilename"`
+
+    // tls port can be served together with the plaintext port, set port to 0 to serve tls only
+    TlsPort        int    `cfg:"tls-port"`
+    TlsCertFile    string `cfg:"tls-cert-file"`
+    TlsKeyFile     string `cfg:"tls-key-file"`
+    // clients must present a certificate signed by the CA if it is set, unless tls-auth-clients is no or optional
+    TlsCaCertFile  string `cfg:"tls-ca-cert-file"`
+    TlsAuthClients string `cfg:"tls-auth-clients"`
+    TlsCluster     bool   `cfg:"tls-cluster"` // dial peers with tls, using tls-cert-file as client certificate
+
     Peers []string `cfg:"peers"`
     Self  string   `cfg:"self"`
 }
@@ -32,10 +61,19 @@ func init() {
     Properties = &ServerProperties{
         Bind:       "127.0.0.1",
         Port:       6379,
-        AppendOnly: false,
+        AppendOnly:               false,
+        AppendFsync:              "everysec",
+        AofLoadTruncated:         true,
+        AutoAofRewritePercentage: 100,
+        AutoAofRewriteMinSize:    64 * 1024 * 1024,
     }
 }
 
+// multiValueKeys are keys which can appear multiple times in config file, their values are concatenated
+var multiValueKeys = map[string]bool{
+    "save": true,
+}
+
 func parse(src io.Reader) *ServerProperties {
     config := &ServerProperties{}
 
@@ -49,9 +87,12 @@ func parse(src io.Reader) *ServerProperties {
         }
         pivot := strings.IndexAny(line, " ")
         if pivot > 0 && pivot < len(line)-1 { // separator found
-            key := line[0:pivot]
+            key := strings.ToLower(line[0:pivot])
             value := strings.Trim(line[pivot+1:], " ")
-            rawMap[strings.ToLower(key)] = value
+            if old, ok := rawMap[key]; ok && multiValueKeys[key] {
+                value = old + " " + value
+            }
+            rawMap[key] = value
         }
     }
     if err := scanner.Err(); err != nil {
@@ -80,6 +121,13 @@ func parse(src io.Reader) *ServerProperties {
                 if err == nil {
                     fieldVal.SetInt(intValue)
                 }
+            case reflect.Int64:
+                intValue, err := ParseMemory(value)
+                if err == nil {
+                    fieldVal.SetInt(intValue)
+                } else {
+                    logger.Warn("invalid value of " + key + ": " + value)
+                }
             case reflect.Bool:
                 boolValue := "yes" == value
                 fieldVal.SetBool(boolValue)
@@ -94,6 +142,38 @@ func parse(src io.Reader) *ServerProperties {
     return config
 }
 
+// ParseMemory parses size with units like redis, e.g. 1k => 1000, 1kb => 1024, 64mb => 64*1024*1024.
+// units are case insensitive
+func ParseMemory(value string) (int64, error) {
+    value = strings.ToLower(strings.TrimSpace(value))
+    units := []struct {
+        suffix string
+        mul    int64
+    }{
+        // 长的后缀放前面，避免 "kb" 被当成 "b"
+        {"kb", 1024},
+        {"mb", 1024 * 1024},
+        {"gb", 1024 * 1024 * 1024},
+        {"k", 1000},
+        {"m", 1000 * 1000},
+        {"g", 1000 * 1000 * 1000},
+        {"b", 1},
+    }
+    mul := int64(1)
+    for _, unit := range units {
+        if strings.HasSuffix(value, unit.suffix) {
+            mul = unit.mul
+            value = strings.TrimSuffix(value, unit.suffix)
+            break
+        }
+    }
+    n, err := strconv.ParseInt(value, 10, 64)
+    if err != nil {
+        return 0, err
+    }
+    return n * mul, nil
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
     file, err := os.Open(configFilename)
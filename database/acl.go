@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"go-redis/acl"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+var noPermKeyErrReply = reply.MakeErrReply("NOPERM No permissions to access a key")
+
+// CheckPermission returns a NOPERM error if the user of connection is not allowed to run the command or to access its keys
+func CheckPermission(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	u, internal := getUser(c)
+	if internal || u == nil {
+		// NOAUTH is replied before checking permission
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !acl.IsCommand(cmdName) {
+		// unknown command error is replied by executor
+		return nil
+	}
+	if !u.CanRun(cmdName) {
+		return reply.MakeErrReply(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", u.Name, cmdName))
+	}
+	for _, key := range commandKeys(cmdName, cmdLine) {
+		if !u.CanAccess(key) {
+			return noPermKeyErrReply
+		}
+	}
+	return nil
+}
+
+// commandKeys returns keys read or written by the command
+func commandKeys(cmdName string, cmdLine [][]byte) []string {
+	if cmdName == "watch" {
+		_, keys := readAllKeys(cmdLine[1:])
+		return keys
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	return append(write, read...)
+}
+
+// execACL executes ACL subcommands
+func execACL(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("acl")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "setuser":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply("acl|setuser")
+		}
+		rules := make([]string, len(args)-1)
+		for i, rule := range args[1:] {
+			rules[i] = string(rule)
+		}
+		if err := acl.SetUser(string(args[0]), rules); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	case "getuser":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("acl|getuser")
+		}
+		return aclGetUser(string(args[0]))
+	case "deluser":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply("acl|deluser")
+		}
+		names := make([]string, len(args))
+		for i, name := range args {
+			names[i] = string(name)
+		}
+		count, err := acl.DelUser(names)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeIntReply(int64(count))
+	case "list":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|list")
+		}
+		users := acl.Users()
+		lines := make([][]byte, len(users))
+		for i, u := range users {
+			lines[i] = []byte(u.Describe())
+		}
+		return reply.MakeMultiBulkReply(lines)
+	case "whoami":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|whoami")
+		}
+		name := c.GetUser()
+		if name == "" {
+			name = acl.DefaultUser
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "cat":
+		return aclCat(args)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try ACL HELP.")
+}
+
+func aclGetUser(name string) resp.Reply {
+	u := acl.GetUser(name)
+	if u == nil {
+		return reply.MakeNullBulkReply()
+	}
+	flags := make([][]byte, 0)
+	for _, flag := range u.Flags() {
+		flags = append(flags, []byte(flag))
+	}
+	passwords := make([][]byte, 0)
+	for _, hash := range u.Passwords() {
+		passwords = append(passwords, []byte(hash))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")),
+		reply.MakeMultiBulkReply(flags),
+		reply.MakeBulkReply([]byte("passwords")),
+		reply.MakeMultiBulkReply(passwords),
+		reply.MakeBulkReply([]byte("commands")),
+		reply.MakeBulkReply([]byte(u.Commands())),
+		reply.MakeBulkReply([]byte("keys")),
+		reply.MakeBulkReply([]byte(u.Keys())),
+	})
+}
+
+// aclCat lists categories, or commands in the given category
+func aclCat(args [][]byte) resp.Reply {
+	var names []string
+	switch len(args) {
+	case 0:
+		names = acl.Categories()
+	case 1:
+		commands, ok := acl.CommandsInCategory(string(args[0]))
+		if !ok {
+			return reply.MakeErrReply("ERR Unknown category '" + string(args[0]) + "'")
+		}
+		names = commands
+	default:
+		return reply.MakeArgNumErrReply("acl|cat")
+	}
+	lines := make([][]byte, len(names))
+	for i, name := range names {
+		lines[i] = []byte(name)
+	}
+	return reply.MakeMultiBulkReply(lines)
+}
+
+func init() {
+	// commands executed by StandaloneDatabase instead of cmdTable
+	acl.RegisterCommand("Auth", acl.CatConnection)
+	acl.RegisterCommand("Select", acl.CatConnection)
+	acl.RegisterCommand("Multi", acl.CatTransaction)
+	acl.RegisterCommand("Discard", acl.CatTransaction)
+	acl.RegisterCommand("Exec", acl.CatTransaction)
+	acl.RegisterCommand("Watch", acl.CatTransaction)
+	acl.RegisterCommand("Unwatch", acl.CatTransaction)
+	acl.RegisterCommand("Info", acl.CatDangerous)
+	acl.RegisterCommand("BGRewriteAOF", acl.CatAdmin, acl.CatDangerous)
+	acl.RegisterCommand("Save", acl.CatAdmin, acl.CatDangerous)
+	acl.RegisterCommand("BGSave", acl.CatAdmin, acl.CatDangerous)
+	acl.RegisterCommand("LastSave", acl.CatAdmin, acl.CatDangerous)
+	acl.RegisterCommand("ACL", acl.CatAdmin, acl.CatDangerous)
+	acl.RegisterCommand("Subscribe", acl.CatPubSub)
+	acl.RegisterCommand("PSubscribe", acl.CatPubSub)
+	acl.RegisterCommand("Unsubscribe", acl.CatPubSub)
+	acl.RegisterCommand("PUnsubscribe", acl.CatPubSub)
+	acl.RegisterCommand("Publish", acl.CatPubSub)
+	acl.RegisterCommand("PubSub", acl.CatPubSub)
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"go-redis/acl"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+)
+
+var (
+	noAuthErrReply    = reply.MakeErrReply("NOAUTH Authentication required.")
+	wrongPassErrReply = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// Auth authenticates the connection, AUTH [username] password
+func Auth(c resp.Connection, args [][]byte) resp.Reply {
+	var name, password string
+	switch len(args) {
+	case 1:
+		name, password = acl.DefaultUser, string(args[0])
+		if u := acl.GetUser(acl.DefaultUser); u != nil && u.NoPass() {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+	case 2:
+		name, password = string(args[0]), string(args[1])
+	default:
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if acl.Authenticate(name, password) == nil {
+		return wrongPassErrReply
+	}
+	c.SetUser(name)
+	c.SetAuthenticated(true)
+	return reply.MakeOkReply()
+}
+
+// getUser returns the acl user of the connection, clients never sent AUTH are the default user.
+// internal is true for connections authenticated without a user, like the one replaying aof, they skip acl checks
+func getUser(c resp.Connection) (u *acl.User, internal bool) {
+	if !c.IsAuthenticated() {
+		return acl.GetUser(acl.DefaultUser), false
+	}
+	if c.GetUser() == "" {
+		return nil, true
+	}
+	return acl.GetUser(c.GetUser()), false
+}
+
+// IsAuthenticated tells whether the client is allowed to execute commands,
+// clients are authenticated as the default user automatically if it requires no password
+func IsAuthenticated(c resp.Connection) bool {
+	u, internal := getUser(c)
+	if internal {
+		return true
+	}
+	if u == nil {
+		return false // the user is deleted
+	}
+	if c.IsAuthenticated() {
+		return true
+	}
+	return u.Enabled() && u.NoPass()
+}
+
+// MakeNoAuthErrReply returns the error for commands sent before authentication
+func MakeNoAuthErrReply() resp.Reply {
+	return noAuthErrReply
+}
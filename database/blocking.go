@@ -0,0 +1,297 @@
+package database
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockingCmd describes how a blocking command waits.
+// executors of blocking commands never block: they reply a null array (a null bulk string if nullBulk is set)
+// if nothing is ready, which is also the reply within MULTI and on timeout.
+// DB.Exec parks the client and retries the command when the keys are written.
+type blockingCmd struct {
+	// parse returns keys to wait for and the timeout, 0 means blocking forever.
+	// block is false if the command doesn't block this time, e.g. XREAD without BLOCK option
+	parse func(args [][]byte) (keys []string, timeout time.Duration, block bool, errReply reply.ErrorReply)
+	// resolve rewrites arguments before the first try if it is not nil, e.g. `$` of XREAD means the last id at this moment
+	// caller holds read locks of the keys
+	resolve func(db *DB, args [][]byte) [][]byte
+	// readOnly commands consume nothing, so all of them are woken by a write rather than the first one
+	readOnly bool
+	// nullBulk commands like BLMOVE reply a null bulk string rather than a null array if nothing is ready
+	nullBulk bool
+}
+
+// nullReply returns the reply of the command if nothing is ready
+func (spec *blockingCmd) nullReply() resp.Reply {
+	if spec.nullBulk {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeNullMultiBulkReply()
+}
+
+// isNullReply tells whether the executor replied nothing is ready
+func (spec *blockingCmd) isNullReply(result resp.Reply) bool {
+	if spec.nullBulk {
+		_, ok := result.(*reply.NullBulkReply)
+		return ok
+	}
+	_, ok := result.(*reply.NullMultiBulkReply)
+	return ok
+}
+
+var blockingCommands = map[string]*blockingCmd{
+	"blpop":    {parse: parseBlockingPop},
+	"brpop":    {parse: parseBlockingPop},
+	"bzpopmin": {parse: parseBlockingPop},
+	"blmove":   {parse: parseBlockingMove, nullBulk: true},
+}
+
+// parseBlockingPop parses commands like `BLPOP key [key ...] timeout`
+func parseBlockingPop(args [][]byte) ([]string, time.Duration, bool, reply.ErrorReply) {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return nil, 0, false, errReply
+	}
+	_, keys := readAllKeys(args[:len(args)-1])
+	return keys, timeout, true, nil
+}
+
+// parseBlockingMove parses `BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout`, it waits for source only
+func parseBlockingMove(args [][]byte) ([]string, time.Duration, bool, reply.ErrorReply) {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return nil, 0, false, errReply
+	}
+	return []string{string(args[0])}, timeout, true, nil
+}
+
+// prepareBlockingPop returns keys of commands like `BLPOP key [key ...] timeout`
+func prepareBlockingPop(args [][]byte) ([]string, []string) {
+	return writeAllKeys(args[:len(args)-1])
+}
+
+// parseBlockTimeout parses timeout of blocking commands in seconds, 0 means blocking forever
+func parseBlockTimeout(arg []byte) (time.Duration, reply.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	// 超出 time.Duration 范围的超时会溢出为负数或很小的值
+	if seconds > math.MaxInt64/float64(time.Second) {
+		return 0, reply.MakeErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// waiter is a client blocked by keys
+type waiter struct {
+	conn     resp.Connection
+	keys     []string
+	readOnly bool
+	// wakeUp is signaled after the waiter is removed from queues because one of its keys was written
+	wakeUp chan struct{}
+}
+
+// blockedClients is the FIFO queues of clients blocked by each key
+type blockedClients struct {
+	count int32 // number of waiters, accessed atomically so writers skip locking if nobody is blocked
+	mu    sync.Mutex
+	keys  map[string][]*waiter
+	conns map[resp.Connection]*waiter
+}
+
+func makeBlockedClients() *blockedClients {
+	return &blockedClients{
+		keys:  make(map[string][]*waiter),
+		conns: make(map[resp.Connection]*waiter),
+	}
+}
+
+// add puts the waiter into queues of its keys, a woken waiter which failed to pop is put at front to keep its turn
+func (bc *blockedClients) add(w *waiter, front bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, key := range w.keys {
+		if front {
+			bc.keys[key] = append([]*waiter{w}, bc.keys[key]...)
+		} else {
+			bc.keys[key] = append(bc.keys[key], w)
+		}
+	}
+	bc.conns[w.conn] = w
+	atomic.AddInt32(&bc.count, 1)
+}
+
+// remove drops the waiter from all queues, it returns false if the waiter is not queued. caller should hold bc.mu
+func (bc *blockedClients) remove(w *waiter) bool {
+	if bc.conns[w.conn] != w {
+		return false
+	}
+	delete(bc.conns, w.conn)
+	for _, key := range w.keys {
+		queue := bc.keys[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(bc.keys, key)
+		} else {
+			bc.keys[key] = queue
+		}
+	}
+	atomic.AddInt32(&bc.count, -1)
+	return true
+}
+
+// isEmpty tells whether no client is blocked
+func (bc *blockedClients) isEmpty() bool {
+	return atomic.LoadInt32(&bc.count) == 0
+}
+
+// wake signals the first waiter and all read only waiters of each key
+func (bc *blockedClients) wake(keys []string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, key := range keys {
+		var woken []*waiter
+		consumerWoken := false
+		for _, w := range bc.keys[key] {
+			if w.readOnly {
+				woken = append(woken, w)
+			} else if !consumerWoken {
+				// the woken consumer wakes the next one after popping, since popping writes the key too
+				woken = append(woken, w)
+				consumerWoken = true
+			}
+		}
+		for _, w := range woken {
+			bc.remove(w)
+			w.wakeUp <- struct{}{}
+		}
+	}
+}
+
+// leaveBlocking removes the waiter which is timed out or disconnected.
+// if it has been woken but won't pop anymore, the next waiter of its keys takes its turn
+func (db *DB) leaveBlocking(w *waiter) {
+	bc := db.blocked
+	bc.mu.Lock()
+	removed := bc.remove(w)
+	bc.mu.Unlock()
+	if !removed {
+		db.wakeBlocked(w.keys)
+	}
+}
+
+// leaveConn removes the waiter of the closed connection if there is one
+func (db *DB) leaveConn(c resp.Connection) {
+	db.blocked.mu.Lock()
+	w, ok := db.blocked.conns[c]
+	db.blocked.mu.Unlock()
+	if ok {
+		db.leaveBlocking(w)
+	}
+}
+
+// wakeBlocked wakes clients blocked by the written keys, it must be called after the keys are unlocked.
+// keys not existing are skipped, otherwise a waiter woken for nothing would lose its turn
+func (db *DB) wakeBlocked(keys []string) {
+	if db.blocked.isEmpty() {
+		return
+	}
+	ready := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := db.data.Get(key); ok {
+			ready = append(ready, key)
+		}
+	}
+	db.blocked.wake(ready)
+}
+
+// execBlocking executes blocking commands like BLPOP, the client waits without holding any lock
+func (db *DB) execBlocking(c resp.Connection, cmdLine [][]byte, spec *blockingCmd) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd := cmdTable[cmdName]
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	keys, timeout, block, errReply := spec.parse(cmdLine[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if !block {
+		return db.execNormalCommand(cmdLine)
+	}
+	if spec.resolve != nil {
+		db.RWLocks(nil, keys)
+		args := spec.resolve(db, cmdLine[1:])
+		db.RWUnLocks(nil, keys)
+		cmdLine = append([][]byte{cmdLine[0]}, args...)
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	w := &waiter{
+		conn:     c,
+		keys:     keys,
+		readOnly: spec.readOnly,
+		wakeUp:   make(chan struct{}, 1),
+	}
+	front := false
+	for {
+		result, blocked := db.tryBlocking(cmd, cmdLine, spec, w, front)
+		if !blocked {
+			return result
+		}
+		c.SetBlocked(true)
+		select {
+		case <-w.wakeUp:
+			front = true
+		case <-deadline:
+			db.leaveBlocking(w)
+			c.SetBlocked(false)
+			return spec.nullReply()
+		case <-c.Done():
+			db.leaveBlocking(w)
+			c.SetBlocked(false)
+			return spec.nullReply()
+		}
+		c.SetBlocked(false)
+	}
+}
+
+// tryBlocking executes the command, the waiter is queued before the keys are unlocked if nothing is popped,
+// so a push after it always wakes the waiter
+func (db *DB) tryBlocking(cmd *command, cmdLine [][]byte, spec *blockingCmd, w *waiter,
+	front bool) (result resp.Reply, blocked bool) {
+	write, read := cmd.prepare(cmdLine[1:])
+	db.RWLocks(write, read)
+	defer func() {
+		db.RWUnLocks(write, read)
+		if !blocked {
+			db.wakeBlocked(write)
+		}
+	}()
+	result = db.execWithoutLock(cmd, cmdLine)
+	if spec.isNullReply(result) {
+		db.blocked.add(w, front)
+		return nil, true
+	}
+	return result, false
+}
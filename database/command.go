@@ -1,24 +1,104 @@
 package database
 
 import (
+	"go-redis/acl"
+	"strconv"
 	"strings"
 )
 
 var cmdTable = make(map[string]*command) //每个指令 都是一个结构体对应
 
+// wholeDBCommands operate all keys of the db, they have no related keys
+// and take all lock slots exclusively instead
+var wholeDBCommands = map[string]struct{}{
+	"flushdb": {},
+	"keys":    {},
+}
+
 // 每个命令都是一个command结构体，里面有一个命令的实现方法
 type command struct {
 	executor ExecFunc
-	arity    int // allow number of args, arity < 0 means len(args) >= -arity  参数的数量 set,put的参数数量不一样
+	prepare  PreFunc // return related keys command
+	arity    int     // allow number of args, arity < 0 means len(args) >= -arity  参数的数量 set,put的参数数量不一样
 }
 
 // RegisterCommand registers a new command
 // arity means allowed number of cmdArgs, arity < 0 means len(args) >= -arity.
 // for example: the arity of `get` is 2, `mget` is -2
-func RegisterCommand(name string, executor ExecFunc, arity int) { //注册对应的命令
+// prepare returns the keys the command writes and reads, it is used by transactions and acl key patterns.
+// categories are acl categories of the command, like acl.CatRead and acl.CatString
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, arity int, categories ...string) { //注册对应的命令
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: executor,
+		prepare:  prepare,
 		arity:    arity,
 	}
+	acl.RegisterCommand(name, categories...)
+}
+
+/* ---- prepare functions ---- */
+
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+func readFirstKey(args [][]byte) ([]string, []string) {
+	// assert len(args) > 0
+	key := string(args[0])
+	return nil, []string{key}
+}
+
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return []string{key}, nil
+}
+
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
+
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
+
+// writeFirstTwoKeys is used by commands like RENAME, SMOVE, LMOVE, which modify source and destination
+func writeFirstTwoKeys(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+// prepareMSet returns keys of `MSET key value [key value ...]`
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
+// prepareStore returns keys of commands like `SINTERSTORE destination key [key ...]`
+func prepareStore(args [][]byte) ([]string, []string) {
+	_, readKeys := readAllKeys(args[1:])
+	return []string{string(args[0])}, readKeys
+}
+
+// prepareZStore returns keys of `ZUNIONSTORE destination numkeys key [key ...] ...`
+func prepareZStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-2 {
+		// the executor will reply the error
+		return []string{dest}, nil
+	}
+	_, readKeys := readAllKeys(args[2 : 2+numKeys])
+	return []string{dest}, readKeys
 }
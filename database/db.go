@@ -5,39 +5,103 @@ import (
 	"go-redis/datastruct/dict"
 	"go-redis/interface/database"
 	"go-redis/interface/resp"
+	"go-redis/lib/lock"
+	"go-redis/lib/sync/atomic"
 	"go-redis/resp/reply"
 	"strings"
+	"sync"
+	"time"
 )
 
 //分库
 
 // DB stores data and execute user's commands
 type DB struct {
+	// dirty is the number of modifications since the last save, accessed atomically.
+	// keep it as the first field to guarantee 64-bit alignment
+	dirty int64
 	index int
 	// key -> DataEntity
-	data   dict.Dict
+	data dict.Dict
+	// key -> expireTime (time.Time)
+	ttlMap dict.Dict
+	// key -> *watchedKey, only keys watched by some connection have versions
+	versionMap dict.Dict
+	// watchMu protects reference counts of watched keys
+	watchMu sync.Mutex
+	// dict.Dict will ensure concurrent-safety of its method
+	// use this mutex for complicated command only, eg. rpush, incr ...
+	locker *lock.Locks
 	addAof func(CmdLine)
+	// loading is true while replaying aof, keys must not expire during loading
+	// otherwise commands after an expired deadline in aof (e.g. rename) would diverge
+	loading atomic.Boolean
+	// notifyFlags is parsed from notify-keyspace-events, 0 means keyspace notifications are disabled
+	notifyFlags int
+	publish     func(channel string, message []byte)
+	// blocked holds clients waiting for keys by commands like BLPOP
+	blocked *blockedClients
+	// snapshot is the rdb snapshot in progress, nil if no snapshot is running.
+	// it is set and cleared while all lock slots are held, so whoever holds the lock of a key can read it
+	snapshot *dbSnapshot
 }
 
 // ExecFunc is interface for command executor
 // args don't include cmd line
 type ExecFunc func(db *DB, args [][]byte) resp.Reply // 指令get \set\put实现
 
+// PreFunc analyses command line when queued command to `multi`
+// returns related write keys and read keys
+type PreFunc func(args [][]byte) ([]string, []string)
+
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
+const (
+	// shard count of dicts in each db
+	dataDictSize    = 1 << 12
+	ttlDictSize     = 1 << 10
+	versionDictSize = 1 << 10
+	// lockerSize is the number of slots in the lock table of each db
+	lockerSize = 1024
+)
+
 // makeDB create DB instance
 func makeDB() *DB {
 	db := &DB{
-		data:   dict.MakeSyncDict(),
-		addAof: func(line CmdLine) {}, //这里为什么要空实现呢？因为aof初始化时会执行命令，这些命令是不需要记录的
+		data:       dict.MakeConcurrent(dataDictSize),
+		ttlMap:     dict.MakeConcurrent(ttlDictSize),
+		versionMap: dict.MakeConcurrent(versionDictSize),
+		locker:     lock.Make(lockerSize),
+		addAof:     func(line CmdLine) {}, //这里为什么要空实现呢？因为aof初始化时会执行命令，这些命令是不需要记录的
+		publish:    func(channel string, message []byte) {},
+		blocked:    makeBlockedClients(),
 	}
 	return db
 }
 
 // Exec executes command within one database
 func (db *DB) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if c != nil {
+		// 事务中的命令在入队时检查权限
+		if errReply := CheckPermission(c, cmdLine); errReply != nil {
+			return errReply
+		}
+	}
+	if c != nil && c.InMultiState() {
+		return EnqueueCmd(c, cmdLine)
+	}
+	// the fake connection replaying aof has no Done channel, blocking commands must not block while replaying.
+	// within MULTI they have been queued above
+	if c != nil && c.Done() != nil {
+		if spec, ok := blockingCommands[strings.ToLower(string(cmdLine[0]))]; ok {
+			return db.execBlocking(c, cmdLine, spec)
+		}
+	}
+	return db.execNormalCommand(cmdLine)
+}
 
+func (db *DB) execNormalCommand(cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
@@ -46,8 +110,31 @@ func (db *DB) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
 	if !validateArity(cmd.arity, cmdLine) { //校验参数
 		return reply.MakeArgNumErrReply(cmdName)
 	}
+	if _, ok := wholeDBCommands[cmdName]; ok {
+		db.locker.LockAll()
+		defer db.locker.UnLockAll()
+		return db.execWithoutLock(cmd, cmdLine)
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	// defers run in reverse order, so blocked clients are woken after the keys are unlocked
+	defer db.wakeBlocked(write)
+	// 按固定顺序对所有相关的 key 加锁，避免死锁
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	return db.execWithoutLock(cmd, cmdLine)
+}
+
+// execWithoutLock executes a validated command, caller should hold locks of related keys
+func (db *DB) execWithoutLock(cmd *command, cmdLine [][]byte) resp.Reply {
+	write, _ := cmd.prepare(cmdLine[1:])
+	db.addVersion(write...)
+	db.preserveForSnapshot(write...)
 	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	result := fun(db, cmdLine[1:])
+	if _, isErr := result.(reply.ErrorReply); !isErr && len(write) > 0 {
+		db.addDirty(int64(len(write)))
+	}
+	return result
 }
 
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -67,6 +154,9 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if !ok {
 		return nil, false
 	}
+	if db.IsExpired(key) { // 惰性删除：访问时才检查是否过期
+		return nil, false
+	}
 	entity, _ := raw.(*database.DataEntity)
 	return entity, true
 }
@@ -78,24 +168,34 @@ func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
 
 // PutIfExists edit an existing DataEntity
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.IsExpired(key) // an expired key counts as not existed
 	return db.data.PutIfExists(key, entity)
 }
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
+	db.IsExpired(key) // an expired key counts as not existed
 	return db.data.PutIfAbsent(key, entity)
 }
 
 // Remove the given key from db
 func (db *DB) Remove(key string) {
 	db.data.Remove(key)
+	db.ttlMap.Remove(key)
+}
+
+// removeEmpty removes a list, set, hash or sorted set emptied by commands like LPOP and SREM,
+// like redis a del event is emitted after the event of the command
+func (db *DB) removeEmpty(key string) {
+	db.Remove(key)
+	db.notify(notifyGeneric, "del", key)
 }
 
 // Removes the given keys from db
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.GetEntity(key)
 		if exists {
 			db.Remove(key)
 			deleted++
@@ -104,8 +204,105 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 	return deleted
 }
 
-// Flush clean database
+// Flush clean database, caller should hold all lock slots
 func (db *DB) Flush() {
+	db.touchAllWatched()
+	db.preserveForSnapshot(db.data.Keys()...)
 	db.data.Clear()
+	db.ttlMap.Clear()
+}
+
+// ForEach traverses all the keys in the db, expiration is nil if the key has no ttl
+func (db *DB) ForEach(cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		entity, _ := raw.(*database.DataEntity)
+		var expiration *time.Time
+		if expireTime, ok := db.TTL(key); ok {
+			expiration = &expireTime
+		}
+		return cb(key, entity, expiration)
+	})
+}
+
+/* ---- Lock Function ----- */
+
+// RWLocks lock keys for writing and reading
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
 
+// RWUnLocks unlock keys for writing and reading
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+/* ---- TTL Functions ---- */
+
+// Expire sets ttlCmd of key
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist cancel ttlCmd of key
+func (db *DB) Persist(key string) {
+	db.ttlMap.Remove(key)
+}
+
+// TTL returns the expire time of the given key, the second return value is false if the key has no ttl
+func (db *DB) TTL(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// IsExpired check whether a key is expired, the expired key will be removed
+func (db *DB) IsExpired(key string) bool {
+	if db.loading.Get() {
+		return false
+	}
+	rawExpireTime, ok := db.ttlMap.Get(key)
+	if !ok {
+		return false
+	}
+	expireTime, _ := rawExpireTime.(time.Time)
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.Remove(key)
+		db.addVersion(key)
+		db.notify(notifyExpired, "expired", key)
+	}
+	return expired
+}
+
+const (
+	// 每轮主动过期抽样的 key 数量
+	activeExpireSampleSize = 20
+	// 每次主动过期最多占用的时间，避免长时间阻塞
+	activeExpireTimeLimit = 25 * time.Millisecond
+)
+
+// activeExpireCycle samples keys with ttl and removes the expired ones.
+// Like redis, it keeps sampling while more than 1/4 of the samples are expired.
+func (db *DB) activeExpireCycle() {
+	start := time.Now()
+	for {
+		keys := db.ttlMap.RandomDistinctKeys(activeExpireSampleSize)
+		if len(keys) == 0 {
+			return
+		}
+		expired := 0
+		for _, key := range keys {
+			// lock the key, otherwise a key rewritten concurrently may be removed by mistake
+			db.locker.Lock(key)
+			if db.IsExpired(key) {
+				expired++
+			}
+			db.locker.UnLock(key)
+		}
+		if expired*4 <= len(keys) || time.Since(start) > activeExpireTimeLimit {
+			return
+		}
+	}
 }
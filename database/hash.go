@@ -0,0 +1,497 @@
+package database
+
+import (
+	"go-redis/acl"
+	Dict "go-redis/datastruct/dict"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//HSET HSETNX HMSET HGET HMGET HDEL HEXISTS HLEN HSTRLEN
+//HKEYS HVALS HGETALL HINCRBY HINCRBYFLOAT HRANDFIELD HSCAN
+
+// 小 hash 使用紧凑的 ListDict 编码，超过下面任一阈值后转换为哈希表编码（同 redis 默认配置）
+const (
+	hashMaxListDictEntries = 128
+	hashMaxListDictValue   = 64
+)
+
+// maxRandomCount limits negative count of HRANDFIELD and SRANDMEMBER, which allows duplicated members,
+// so the reply isn't bounded by the size of collection
+const maxRandomCount = 1 << 20
+
+// parseRandomCount parses count of commands like HRANDFIELD, a negative result is not less than -maxRandomCount
+func parseRandomCount(arg []byte) (int64, reply.ErrorReply) {
+	count, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	// 不能先取 -count 再判断，MinInt64 取反会溢出
+	if count < -maxRandomCount {
+		return 0, reply.MakeErrReply("ERR value is out of range")
+	}
+	return count, nil
+}
+
+func (db *DB) getAsDict(key string) (Dict.Dict, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return dict, nil
+}
+
+func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply reply.ErrorReply) {
+	dict, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if dict == nil {
+		dict = Dict.MakeListDict()
+		db.PutEntity(key, &database.DataEntity{
+			Data: dict,
+		})
+		inited = true
+	}
+	return dict, inited, nil
+}
+
+// hashPut puts field into hash, and converts the compact encoding into hashtable if it grows too big
+// returns the dict after conversion and the number of new inserted field
+func (db *DB) hashPut(key string, dict Dict.Dict, field string, value []byte) (Dict.Dict, int) {
+	result := dict.Put(field, value)
+	listDict, ok := dict.(*Dict.ListDict)
+	if !ok {
+		return dict, result
+	}
+	if listDict.Len() <= hashMaxListDictEntries &&
+		len(field) <= hashMaxListDictValue && len(value) <= hashMaxListDictValue {
+		return dict, result
+	}
+	hashtable := Dict.MakeSimple()
+	listDict.ForEach(func(k string, v interface{}) bool {
+		hashtable.Put(k, v)
+		return true
+	})
+	entity, _ := db.GetEntity(key)
+	entity.Data = hashtable
+	return hashtable, result
+}
+
+// execHSet sets field in hash table
+// HSET key field value [field value ...]
+func execHSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	inserted := 0
+	for i := 1; i < len(args); i += 2 {
+		var result int
+		dict, result = db.hashPut(key, dict, string(args[i]), args[i+1])
+		inserted += result
+	}
+	db.addAof(utils.ToCmdLine2("hset", args...))
+	db.notify(notifyHash, "hset", key)
+	return reply.MakeIntReply(int64(inserted))
+}
+
+// execHMSet sets multi fields in hash table
+func execHMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hmset")
+	}
+	result := execHSet(db, args)
+	if reply.IsErrorReply(result) {
+		return result
+	}
+	return &reply.OkReply{}
+}
+
+// execHSetNX sets field in hash table only if field not exists
+func execHSetNX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	value := args[2]
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if _, exists := dict.Get(field); exists {
+		return reply.MakeIntReply(0)
+	}
+	db.hashPut(key, dict, field, value)
+	db.addAof(utils.ToCmdLine2("hsetnx", args...))
+	db.notify(notifyHash, "hset", key)
+	return reply.MakeIntReply(1)
+}
+
+// execHGet gets field value of hash table
+func execHGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.NullBulkReply{}
+	}
+	raw, exists := dict.Get(field)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeBulkReply(value)
+}
+
+// execHMGet gets multi fields in hash table
+func execHMGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := make([][]byte, len(args)-1)
+	if dict == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, field := range args[1:] {
+		raw, exists := dict.Get(string(field))
+		if exists {
+			result[i], _ = raw.([]byte)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHExists checks if a hash field exists
+func execHExists(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists := dict.Get(field); exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execHDel deletes a hash field
+func execHDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		deleted += dict.Remove(string(field))
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("hdel", args...))
+		db.notify(notifyHash, "hdel", key)
+	}
+	if dict.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execHLen gets number of fields in hash table
+func execHLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(dict.Len()))
+}
+
+// execHStrlen gets string length of field value in hash table
+func execHStrlen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	raw, exists := dict.Get(field)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// execHGetAll gets all key-value entries in hash table
+func execHGetAll(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	result := make([][]byte, 0, dict.Len()*2)
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		result = append(result, []byte(field), value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHKeys gets all field names in hash table
+func execHKeys(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	fields := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		fields = append(fields, []byte(field))
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// execHVals gets all field value in hash table
+func execHVals(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	values := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		values = append(values, value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(values)
+}
+
+// execHIncrBy increments the integer value of a hash field by the given number
+func execHIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	value, exists := dict.Get(field)
+	if !exists {
+		// 存规范化后的数字，"+05" 应存为 "5"
+		db.hashPut(key, dict, field, []byte(strconv.FormatInt(delta, 10)))
+		db.addAof(utils.ToCmdLine2("hincrby", args...))
+		db.notify(notifyHash, "hincrby", key)
+		return reply.MakeIntReply(delta)
+	}
+	val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR hash value is not an integer")
+	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	db.hashPut(key, dict, field, []byte(strconv.FormatInt(val, 10)))
+	db.addAof(utils.ToCmdLine2("hincrby", args...))
+	db.notify(notifyHash, "hincrby", key)
+	return reply.MakeIntReply(val)
+}
+
+// execHIncrByFloat increments the float value of a hash field by the given number
+func execHIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	val := float64(0)
+	if raw, exists := dict.Get(field); exists {
+		val, err = strconv.ParseFloat(string(raw.([]byte)), 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	val += delta
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	result := []byte(strconv.FormatFloat(val, 'f', -1, 64))
+	db.hashPut(key, dict, field, result)
+	// 浮点运算在不同平台可能有误差，aof 中直接记录结果
+	db.addAof(utils.ToCmdLine2("hset", args[0], args[1], result))
+	db.notify(notifyHash, "hincrbyfloat", key)
+	return reply.MakeBulkReply(result)
+}
+
+// execHRandField returns random fields from hash table
+// HRANDFIELD key [count [WITHVALUES]]
+func execHRandField(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if len(args) > 3 {
+		return reply.MakeArgNumErrReply("hrandfield")
+	}
+	withCount := len(args) >= 2
+	count := int64(1)
+	if withCount {
+		var errReply reply.ErrorReply
+		count, errReply = parseRandomCount(args[1])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return &reply.SyntaxErrReply{}
+		}
+		withValues = true
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		if withCount {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	var fields []string
+	if count >= 0 {
+		fields = dict.RandomDistinctKeys(int(count))
+	} else {
+		fields = dict.RandomKeys(int(-count))
+	}
+	if !withCount {
+		return reply.MakeBulkReply([]byte(fields[0]))
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			raw, _ := dict.Get(field)
+			value, _ := raw.([]byte)
+			result = append(result, value)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHScan iterates fields of hash table
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func execHScan(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+	fields, nextCursor := dict.DictScan(scan.cursor, scan.count, scan.pattern)
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		raw, exists := dict.Get(string(field))
+		if !exists {
+			continue
+		}
+		value, _ := raw.([]byte)
+		result = append(result, field, value)
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, -4, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, 4, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, -4, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HGet", execHGet, readFirstKey, 3, acl.CatRead, acl.CatHash)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, -3, acl.CatRead, acl.CatHash)
+	RegisterCommand("HExists", execHExists, readFirstKey, 3, acl.CatRead, acl.CatHash)
+	RegisterCommand("HDel", execHDel, writeFirstKey, -3, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HLen", execHLen, readFirstKey, 2, acl.CatRead, acl.CatHash)
+	RegisterCommand("HStrlen", execHStrlen, readFirstKey, 3, acl.CatRead, acl.CatHash)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, 2, acl.CatRead, acl.CatHash)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, 2, acl.CatRead, acl.CatHash)
+	RegisterCommand("HVals", execHVals, readFirstKey, 2, acl.CatRead, acl.CatHash)
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, 4, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, 4, acl.CatWrite, acl.CatHash)
+	RegisterCommand("HRandField", execHRandField, readFirstKey, -2, acl.CatRead, acl.CatHash)
+	RegisterCommand("HScan", execHScan, readFirstKey, -3, acl.CatRead, acl.CatHash)
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+	"time"
+)
+
+// INFO [section]
+
+// execInfo returns information and statistics about the server
+func execInfo(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	section := "default"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	} else if len(args) > 1 {
+		return &reply.SyntaxErrReply{}
+	}
+	all := section == "default" || section == "all" || section == "everything"
+
+	var sb strings.Builder
+	if all || section == "persistence" {
+		mdb.writePersistenceInfo(&sb)
+	}
+	if all || section == "keyspace" {
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		mdb.writeKeyspaceInfo(&sb)
+	}
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
+
+func (mdb *StandaloneDatabase) writePersistenceInfo(sb *strings.Builder) {
+	sb.WriteString("# Persistence\r\n")
+	mdb.saveMu.Lock()
+	lastSave, lastSaveErr, lastSaveCost := mdb.lastSave, mdb.lastSaveErr, mdb.lastSaveCost
+	mdb.saveMu.Unlock()
+	fmt.Fprintf(sb, "rdb_changes_since_last_save:%d\r\n", mdb.dirtyCount())
+	fmt.Fprintf(sb, "rdb_bgsave_in_progress:%d\r\n", boolToInt(mdb.saving.Get()))
+	fmt.Fprintf(sb, "rdb_last_save_time:%d\r\n", lastSave.Unix())
+	fmt.Fprintf(sb, "rdb_last_bgsave_status:%s\r\n", statusOf(lastSaveErr))
+	fmt.Fprintf(sb, "rdb_last_bgsave_time_sec:%d\r\n", int64(lastSaveCost/time.Second))
+	if mdb.aofHandler == nil {
+		sb.WriteString("aof_enabled:0\r\n")
+		return
+	}
+	stats := mdb.aofHandler.Stats()
+	sb.WriteString("aof_enabled:1\r\n")
+	fmt.Fprintf(sb, "aof_fsync:%s\r\n", stats.Policy)
+	fmt.Fprintf(sb, "aof_last_write_status:%s\r\n", statusOf(stats.LastWriteError))
+	fmt.Fprintf(sb, "aof_write_errors:%d\r\n", stats.WriteErrors)
+	fmt.Fprintf(sb, "aof_last_fsync_status:%s\r\n", statusOf(stats.LastFsyncError))
+	if stats.LastFsyncError != nil {
+		// 错误信息里可能带有换行，替换掉以免破坏 INFO 的格式
+		fmt.Fprintf(sb, "aof_last_fsync_error:%s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(stats.LastFsyncError.Error()))
+	}
+	fmt.Fprintf(sb, "aof_fsync_errors:%d\r\n", stats.FsyncErrors)
+	lastFsync := int64(-1)
+	if !stats.LastFsyncTime.IsZero() {
+		lastFsync = stats.LastFsyncTime.Unix()
+	}
+	fmt.Fprintf(sb, "aof_last_fsync_time:%d\r\n", lastFsync)
+	fmt.Fprintf(sb, "aof_fsync_lag_ms:%d\r\n", stats.Lag/time.Millisecond)
+	fmt.Fprintf(sb, "aof_last_fsync_cost_ms:%d\r\n", stats.LastFsyncCost/time.Millisecond)
+	fmt.Fprintf(sb, "aof_delayed_fsync:%d\r\n", stats.DelayedFsyncs)
+}
+
+func (mdb *StandaloneDatabase) writeKeyspaceInfo(sb *strings.Builder) {
+	sb.WriteString("# Keyspace\r\n")
+	for i, db := range mdb.dbSet {
+		keys := db.data.Len()
+		if keys == 0 {
+			continue
+		}
+		fmt.Fprintf(sb, "db%d:keys=%d,expires=%d\r\n", i, keys, db.ttlMap.Len())
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func statusOf(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
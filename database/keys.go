@@ -1,10 +1,22 @@
 package database
 
 import (
+	"go-redis/acl"
+	"go-redis/aof"
+	"go-redis/datastruct/dict"
+	"go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	"go-redis/datastruct/sortedset"
+	"go-redis/datastruct/stream"
+	"go-redis/interface/database"
 	"go-redis/interface/resp"
 	"go-redis/lib/utils"
 	"go-redis/lib/wildcard"
 	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //DEL
@@ -14,6 +26,10 @@ import (
 //TYPE
 //RENAME
 //RENAMENX
+//EXPIRE PEXPIRE EXPIREAT PEXPIREAT
+//TTL PTTL
+//PERSIST
+//SCAN
 
 // execDel removes a key from db
 func execDel(db *DB, args [][]byte) resp.Reply {
@@ -21,7 +37,15 @@ func execDel(db *DB, args [][]byte) resp.Reply {
 	for i, v := range args {
 		keys[i] = string(v)
 	}
-	deleted := db.Removes(keys...)
+	deleted := 0
+	for _, key := range keys {
+		if _, exists := db.GetEntity(key); !exists {
+			continue
+		}
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+		deleted++
+	}
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine2("del", args...))
 	}
@@ -43,31 +67,44 @@ func execExists(db *DB, args [][]byte) resp.Reply {
 
 // execFlushDB removes all data in current db
 func execFlushDB(db *DB, args [][]byte) resp.Reply {
+	db.addDirty(int64(db.data.Len()))
 	db.Flush()
 	db.addAof(utils.ToCmdLine2("flushdb", args...))
 	return &reply.OkReply{}
 }
 
-// execType returns the type of entity, including: string, list, hash, set and zset
+// getTypeName returns the type name of entity, including: string, list, hash, set, zset and stream
+// it returns empty string for unknown type
+func getTypeName(entity *database.DataEntity) string {
+	switch entity.Data.(type) {
+	case []byte:
+		return "string"
+	case list.List:
+		return "list"
+	case dict.Dict:
+		return "hash"
+	case *set.Set:
+		return "set"
+	case *sortedset.SortedSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
+	}
+	return ""
+}
+
+// execType returns the type of entity, including: string, list, hash, set, zset and stream
 func execType(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	entity, exists := db.GetEntity(key)
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
-	switch entity.Data.(type) {
-	case []byte:
-		return reply.MakeStatusReply("string")
-		//case *list.LinkedList:
-		//    return reply.MakeStatusReply("list")
-		//case dict.Dict:
-		//    return reply.MakeStatusReply("hash")
-		//case *set.Set:
-		//    return reply.MakeStatusReply("set")
-		//case *sortedset.SortedSet:
-		//    return reply.MakeStatusReply("zset")
+	typeName := getTypeName(entity)
+	if typeName == "" {
+		return &reply.UnknownErrReply{}
 	}
-	return &reply.UnknownErrReply{}
+	return reply.MakeStatusReply(typeName)
 }
 
 // execRename a key
@@ -82,9 +119,18 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	if src == dest {
+		return &reply.OkReply{}
+	}
+	expireTime, hasTTL := db.TTL(src)
+	db.Removes(src, dest) // clean src and dest with their ttl
 	db.PutEntity(dest, entity)
-	db.Remove(src)
+	if hasTTL { // dest 继承 src 的过期时间
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("rename", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return &reply.OkReply{}
 }
 
@@ -102,9 +148,15 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	expireTime, hasTTL := db.TTL(src)
 	db.Removes(src, dest) // clean src and dest with their ttl
 	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("renamenx", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return reply.MakeIntReply(1)
 }
 
@@ -113,7 +165,7 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.IsExpired(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -121,12 +173,235 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+// scanArgs is parsed arguments of SCAN family
+type scanArgs struct {
+	cursor   int
+	count    int
+	pattern  string
+	typeName string // only used by SCAN, empty means no filter
+}
+
+// parseScanArgs parses `cursor [MATCH pattern] [COUNT count] [TYPE type]`, TYPE is allowed only if allowType is true
+func parseScanArgs(args [][]byte, allowType bool) (*scanArgs, reply.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 31)
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	result := &scanArgs{
+		cursor:  int(cursor),
+		count:   10,
+		pattern: "*",
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, &reply.SyntaxErrReply{}
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			result.pattern = string(args[i+1])
+		case "COUNT":
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			result.count = count
+		case "TYPE":
+			if !allowType {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			result.typeName = strings.ToLower(string(args[i+1]))
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+	}
+	return result, nil
+}
+
+// makeScanReply returns [cursor, [elements...]]
+func makeScanReply(cursor int, elements [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(cursor))),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
+// execScan iterates keys in db incrementally
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	keys, nextCursor := db.data.DictScan(scan.cursor, scan.count, scan.pattern)
+	result := make([][]byte, 0, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		// scan doesn't hold any key lock, so skip expired keys without removing them
+		if expireTime, ok := db.TTL(string(key)); ok && now.After(expireTime) {
+			continue
+		}
+		if scan.typeName != "" {
+			raw, exists := db.data.Get(string(key))
+			if !exists || getTypeName(raw.(*database.DataEntity)) != scan.typeName {
+				continue
+			}
+		}
+		result = append(result, key)
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+// expireAt sets the absolute expire time of key, a time in the past deletes the key immediately
+func expireAt(db *DB, key string, expireTime time.Time) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !expireTime.After(time.Now()) && !db.loading.Get() {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		db.notify(notifyGeneric, "del", key)
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.addAof(aof.MakeExpireCmd(key, expireTime))
+	db.notify(notifyGeneric, "expire", key)
+	return reply.MakeIntReply(1)
+}
+
+// parseTTLArg parses integer argument of expire commands
+func parseTTLArg(arg []byte) (int64, reply.ErrorReply) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return val, nil
+}
+
+// bounds of expire time in unix milliseconds, so that it is representable by unix nanoseconds
+const (
+	maxExpireMs = math.MaxInt64 / int64(time.Millisecond)
+	minExpireMs = math.MinInt64 / int64(time.Millisecond)
+)
+
+// toExpireTime converts the argument of expire commands into absolute expire time.
+// unit is milliseconds of the argument unit, relative means val is a ttl from now.
+// it returns false if the result overflows, otherwise the wrapped deadline would delete the key silently
+func toExpireTime(val int64, unit int64, relative bool) (time.Time, bool) {
+	if val > maxExpireMs/unit || val < minExpireMs/unit {
+		return time.Time{}, false
+	}
+	ms := val * unit
+	if relative {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		if (ms > 0 && ms > maxExpireMs-now) || (ms < 0 && ms < minExpireMs-now) {
+			return time.Time{}, false
+		}
+		ms += now
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+func makeInvalidExpireErr(cmdName string) reply.ErrorReply {
+	return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT
+func expireGeneric(db *DB, args [][]byte, cmdName string, unit int64, relative bool) resp.Reply {
+	val, errReply := parseTTLArg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := toExpireTime(val, unit, relative)
+	if !ok {
+		return makeInvalidExpireErr(cmdName)
+	}
+	return expireAt(db, string(args[0]), expireTime)
+}
+
+// execExpire sets a key's time to live in seconds
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, "expire", 1000, true)
+}
+
+// execPExpire sets a key's time to live in milliseconds
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, "pexpire", 1, true)
+}
+
+// execExpireAt sets a key's expiration in unix timestamp
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, "expireat", 1000, false)
+}
+
+// execPExpireAt sets a key's expiration in unix timestamp specified in milliseconds
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, "pexpireat", 1, false)
+}
+
+// execTTL returns a key's time to live in seconds
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.TTL(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	return reply.MakeIntReply(int64((ttl + 500*time.Millisecond) / time.Second))
+}
+
+// execPTTL returns a key's time to live in milliseconds
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.TTL(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	return reply.MakeIntReply(int64(time.Until(expireTime) / time.Millisecond))
+}
+
+// execPersist removes expiration from a key
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	_, ok := db.TTL(key)
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	db.notify(notifyGeneric, "persist", key)
+	return reply.MakeIntReply(1)
+}
+
 func init() {
-	RegisterCommand("Del", execDel, -2)
-	RegisterCommand("Exists", execExists, -2)
-	RegisterCommand("Keys", execKeys, 2)
-	RegisterCommand("FlushDB", execFlushDB, -1)
-	RegisterCommand("Type", execType, 2)
-	RegisterCommand("Rename", execRename, 3)
-	RegisterCommand("RenameNx", execRenameNx, 3)
+	RegisterCommand("Del", execDel, writeAllKeys, -2, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("Exists", execExists, readAllKeys, -2, acl.CatRead, acl.CatKeyspace)
+	RegisterCommand("Keys", execKeys, noPrepare, 2, acl.CatRead, acl.CatKeyspace, acl.CatDangerous)
+	RegisterCommand("Scan", execScan, noPrepare, -2, acl.CatRead, acl.CatKeyspace)
+	RegisterCommand("FlushDB", execFlushDB, noPrepare, -1, acl.CatWrite, acl.CatKeyspace, acl.CatDangerous)
+	RegisterCommand("Type", execType, readFirstKey, 2, acl.CatRead, acl.CatKeyspace)
+	RegisterCommand("Rename", execRename, writeFirstTwoKeys, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("RenameNx", execRenameNx, writeFirstTwoKeys, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("Expire", execExpire, writeFirstKey, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, 3, acl.CatWrite, acl.CatKeyspace)
+	RegisterCommand("TTL", execTTL, readFirstKey, 2, acl.CatRead, acl.CatKeyspace)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, 2, acl.CatRead, acl.CatKeyspace)
+	RegisterCommand("Persist", execPersist, writeFirstKey, 2, acl.CatWrite, acl.CatKeyspace)
 }
@@ -0,0 +1,626 @@
+package database
+
+import (
+	"go-redis/acl"
+	List "go-redis/datastruct/list"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+//LPUSH LPUSHX RPUSH RPUSHX
+//LPOP RPOP RPOPLPUSH LMOVE
+//BLPOP BRPOP BLMOVE
+//LRANGE LINDEX LSET LREM LTRIM LINSERT LLEN
+
+func (db *DB) getAsList(key string) (List.List, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	list, ok := entity.Data.(List.List)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return list, nil
+}
+
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply reply.ErrorReply) {
+	list, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if list == nil {
+		list = List.NewQuickList()
+		db.PutEntity(key, &database.DataEntity{
+			Data: list,
+		})
+		isNew = true
+	}
+	return list, isNew, nil
+}
+
+// parseListIndex parses integer argument of list commands
+func parseListIndex(arg []byte) (int64, reply.ErrorReply) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return val, nil
+}
+
+// execLPush inserts element at head of list
+func execLPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+	db.addAof(utils.ToCmdLine2("lpush", args...))
+	db.notify(notifyList, "lpush", key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLPushX inserts element at head of list, only if list exists
+func execLPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+	db.addAof(utils.ToCmdLine2("lpushx", args...))
+	db.notify(notifyList, "lpush", key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPush inserts element at last of list
+func execRPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.Add(value)
+	}
+	db.addAof(utils.ToCmdLine2("rpush", args...))
+	db.notify(notifyList, "rpush", key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPushX inserts element at last of list only if list exists
+func execRPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.Add(value)
+	}
+	db.addAof(utils.ToCmdLine2("rpushx", args...))
+	db.notify(notifyList, "rpush", key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// popGeneric implements LPOP and RPOP: LPOP key [count]
+func popGeneric(db *DB, args [][]byte, cmdName string, fromHead bool) resp.Reply {
+	key := string(args[0])
+	count := int64(1)
+	withCount := len(args) > 1
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	if withCount {
+		var errReply reply.ErrorReply
+		count, errReply = parseListIndex(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		if count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if withCount {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	// count comes from client, never allocate more than the list holds
+	if count > int64(list.Len()) {
+		count = int64(list.Len())
+	}
+	result := make([][]byte, 0, count)
+	for i := int64(0); i < count && list.Len() > 0; i++ {
+		var val interface{}
+		if fromHead {
+			val = list.Remove(0)
+		} else {
+			val = list.RemoveLast()
+		}
+		result = append(result, val.([]byte))
+	}
+	if len(result) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+		db.notify(notifyList, cmdName, key)
+	}
+	if list.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	if withCount {
+		return reply.MakeMultiBulkReply(result)
+	}
+	return reply.MakeBulkReply(result[0])
+}
+
+// execLPop removes the first element of list, and return it
+func execLPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, args, "lpop", true)
+}
+
+// execRPop removes last element of list then return it
+func execRPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, args, "rpop", false)
+}
+
+// moveElement pops an element from source and pushes it into destination
+// returns nil if source doesn't exist
+func moveElement(db *DB, source, destination string, fromHead, toHead bool) ([]byte, reply.ErrorReply) {
+	srcList, errReply := db.getAsList(source)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if srcList == nil {
+		return nil, nil
+	}
+	// check type of destination before modifying anything
+	if _, errReply = db.getAsList(destination); errReply != nil {
+		return nil, errReply
+	}
+
+	var val []byte
+	if fromHead {
+		val, _ = srcList.Remove(0).([]byte)
+		db.notify(notifyList, "lpop", source)
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+		db.notify(notifyList, "rpop", source)
+	}
+	// 源和目标相同时原地旋转，不能删除 key，否则会丢失 TTL
+	if srcList.Len() == 0 && source != destination {
+		db.removeEmpty(source)
+	}
+	destList, _, _ := db.getOrInitList(destination)
+	if toHead {
+		destList.Insert(0, val)
+		db.notify(notifyList, "lpush", destination)
+	} else {
+		destList.Add(val)
+		db.notify(notifyList, "rpush", destination)
+	}
+	return val, nil
+}
+
+// execRPopLPush pops last element of list-A then insert it to the head of list-B
+func execRPopLPush(db *DB, args [][]byte) resp.Reply {
+	val, errReply := moveElement(db, string(args[0]), string(args[1]), false, true)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.addAof(utils.ToCmdLine2("rpoplpush", args...))
+	return reply.MakeBulkReply(val)
+}
+
+// parseDirection parses LEFT/RIGHT argument, returns true for LEFT
+func parseDirection(arg []byte) (bool, reply.ErrorReply) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, &reply.SyntaxErrReply{}
+}
+
+// execLMove atomically pops an element from source and pushes it to destination
+// LMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT>
+func execLMove(db *DB, args [][]byte) resp.Reply {
+	fromHead, errReply := parseDirection(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	toHead, errReply := parseDirection(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	val, errReply := moveElement(db, string(args[0]), string(args[1]), fromHead, toHead)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.addAof(utils.ToCmdLine2("lmove", args...))
+	return reply.MakeBulkReply(val)
+}
+
+// blockingPopGeneric implements BLPOP and BRPOP: BLPOP key [key ...] timeout
+// it pops from the first non-empty list, or replies a null array and DB.Exec blocks the client.
+// aof records the equivalent LPOP or RPOP so replaying never blocks
+func blockingPopGeneric(db *DB, args [][]byte, popCmd string, fromHead bool) resp.Reply {
+	if _, errReply := parseBlockTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		var val interface{}
+		if fromHead {
+			val = list.Remove(0)
+		} else {
+			val = list.RemoveLast()
+		}
+		db.addAof(utils.ToCmdLine2(popCmd, arg))
+		db.notify(notifyList, popCmd, key)
+		if list.Len() == 0 {
+			db.removeEmpty(key)
+		}
+		return reply.MakeMultiBulkReply([][]byte{arg, val.([]byte)})
+	}
+	return reply.MakeNullMultiBulkReply()
+}
+
+// execBLPop removes and returns the first element of the first non-empty list, blocks if all lists are empty
+func execBLPop(db *DB, args [][]byte) resp.Reply {
+	return blockingPopGeneric(db, args, "lpop", true)
+}
+
+// execBRPop removes and returns the last element of the first non-empty list, blocks if all lists are empty
+func execBRPop(db *DB, args [][]byte) resp.Reply {
+	return blockingPopGeneric(db, args, "rpop", false)
+}
+
+// execBLMove is the blocking version of LMOVE, aof records LMOVE
+// BLMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT> timeout
+func execBLMove(db *DB, args [][]byte) resp.Reply {
+	fromHead, errReply := parseDirection(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	toHead, errReply := parseDirection(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	if _, errReply = parseBlockTimeout(args[4]); errReply != nil {
+		return errReply
+	}
+	val, errReply := moveElement(db, string(args[0]), string(args[1]), fromHead, toHead)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.addAof(utils.ToCmdLine2("lmove", args[:4]...))
+	return reply.MakeBulkReply(val)
+}
+
+// execLLen gets length of list
+func execLLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLIndex gets element of list at given list
+func execLIndex(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, errReply := parseListIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	index := int(index64)
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	size := list.Len() // assert: size > 0
+	if index < -1*size {
+		return &reply.NullBulkReply{}
+	} else if index < 0 {
+		index = size + index
+	} else if index >= size {
+		return &reply.NullBulkReply{}
+	}
+
+	val, _ := list.Get(index).([]byte)
+	return reply.MakeBulkReply(val)
+}
+
+// execLSet puts element at the given index of list
+func execLSet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, errReply := parseListIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	index := int(index64)
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+
+	size := list.Len() // assert: size > 0
+	if index < -1*size {
+		return reply.MakeErrReply("ERR index out of range")
+	} else if index < 0 {
+		index = size + index
+	} else if index >= size {
+		return reply.MakeErrReply("ERR index out of range")
+	}
+
+	list.Set(index, value)
+	db.addAof(utils.ToCmdLine2("lset", args...))
+	db.notify(notifyList, "lset", key)
+	return &reply.OkReply{}
+}
+
+// normalizeRange converts redis style start/stop (inclusive, negative allowed) into [start, stop)
+// returns start >= stop if the range is empty
+func normalizeRange(start, stop int64, size int64) (int, int) {
+	if start < -1*size {
+		start = 0
+	} else if start < 0 {
+		start = size + start
+	} else if start >= size {
+		return 0, 0
+	}
+	if stop < -1*size {
+		stop = 0
+	} else if stop < 0 {
+		stop = size + stop + 1
+	} else if stop < size {
+		stop = stop + 1
+	} else {
+		stop = size
+	}
+	if stop < start {
+		stop = start
+	}
+	return int(start), int(stop)
+}
+
+// execLRange gets elements of list in given range
+func execLRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start64, errReply := parseListIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	stop64, errReply := parseListIndex(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	start, stop := normalizeRange(start64, stop64, int64(list.Len()))
+	if start >= stop {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	slice := list.Range(start, stop)
+	result := make([][]byte, len(slice))
+	for i, raw := range slice {
+		bytes, _ := raw.([]byte)
+		result[i] = bytes
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execLRem removes element of list at specified index
+func execLRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count64, errReply := parseListIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	count := int(count64)
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	expected := func(a interface{}) bool {
+		bytes, _ := a.([]byte)
+		return utils.BytesEquals(bytes, value)
+	}
+	var removed int
+	if count == 0 {
+		removed = list.RemoveAllByVal(expected)
+	} else if count > 0 {
+		removed = list.RemoveByVal(expected, count)
+	} else {
+		removed = list.ReverseRemoveByVal(expected, -count)
+	}
+
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("lrem", args...))
+		db.notify(notifyList, "lrem", key)
+	}
+	if list.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execLTrim trims list so that it will contain only the specified range of elements
+func execLTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start64, errReply := parseListIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	stop64, errReply := parseListIndex(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.OkReply{}
+	}
+
+	start, stop := normalizeRange(start64, stop64, int64(list.Len()))
+	if start >= stop {
+		db.addAof(utils.ToCmdLine2("ltrim", args...))
+		db.notify(notifyList, "ltrim", key)
+		db.removeEmpty(key)
+		return &reply.OkReply{}
+	}
+	for list.Len() > stop {
+		list.RemoveLast()
+	}
+	for i := 0; i < start; i++ {
+		list.Remove(0)
+	}
+	db.addAof(utils.ToCmdLine2("ltrim", args...))
+	db.notify(notifyList, "ltrim", key)
+	return &reply.OkReply{}
+}
+
+// execLInsert inserts element before or after the pivot element
+// LINSERT key <BEFORE | AFTER> pivot element
+func execLInsert(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return &reply.SyntaxErrReply{}
+	}
+	pivot := args[2]
+	value := args[3]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	index := -1
+	list.ForEach(func(i int, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		if utils.BytesEquals(bytes, pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if !before {
+		index++
+	}
+	list.Insert(index, value)
+	db.addAof(utils.ToCmdLine2("linsert", args...))
+	db.notify(notifyList, "linsert", key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func init() {
+	RegisterCommand("LPush", execLPush, writeFirstKey, -3, acl.CatWrite, acl.CatList)
+	RegisterCommand("LPushX", execLPushX, writeFirstKey, -3, acl.CatWrite, acl.CatList)
+	RegisterCommand("RPush", execRPush, writeFirstKey, -3, acl.CatWrite, acl.CatList)
+	RegisterCommand("RPushX", execRPushX, writeFirstKey, -3, acl.CatWrite, acl.CatList)
+	RegisterCommand("LPop", execLPop, writeFirstKey, -2, acl.CatWrite, acl.CatList)
+	RegisterCommand("RPop", execRPop, writeFirstKey, -2, acl.CatWrite, acl.CatList)
+	RegisterCommand("RPopLPush", execRPopLPush, writeFirstTwoKeys, 3, acl.CatWrite, acl.CatList)
+	RegisterCommand("LMove", execLMove, writeFirstTwoKeys, 5, acl.CatWrite, acl.CatList)
+	RegisterCommand("BLPop", execBLPop, prepareBlockingPop, -3, acl.CatWrite, acl.CatList, acl.CatBlocking)
+	RegisterCommand("BRPop", execBRPop, prepareBlockingPop, -3, acl.CatWrite, acl.CatList, acl.CatBlocking)
+	RegisterCommand("BLMove", execBLMove, writeFirstTwoKeys, 6, acl.CatWrite, acl.CatList, acl.CatBlocking)
+	RegisterCommand("LLen", execLLen, readFirstKey, 2, acl.CatRead, acl.CatList)
+	RegisterCommand("LIndex", execLIndex, readFirstKey, 3, acl.CatRead, acl.CatList)
+	RegisterCommand("LSet", execLSet, writeFirstKey, 4, acl.CatWrite, acl.CatList)
+	RegisterCommand("LRange", execLRange, readFirstKey, 4, acl.CatRead, acl.CatList)
+	RegisterCommand("LRem", execLRem, writeFirstKey, 4, acl.CatWrite, acl.CatList)
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, 4, acl.CatWrite, acl.CatList)
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, 5, acl.CatWrite, acl.CatList)
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+)
+
+// keyspace event classes, enabled by flag letters of `notify-keyspace-events` config
+const (
+	notifyKeyspace = 1 << iota // K: __keyspace@<db>__:<key> channel, message is the event
+	notifyKeyevent             // E: __keyevent@<db>__:<event> channel, message is the key
+	notifyGeneric              // g: generic commands like DEL, EXPIRE, RENAME
+	notifyString               // $: string commands
+	notifyList                 // l: list commands
+	notifySet                  // s: set commands
+	notifyHash                 // h: hash commands
+	notifyZSet                 // z: sorted set commands
+	notifyExpired              // x: a key expired
+	notifyStream               // t: stream commands
+
+	// notifyAll is the alias A
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyStream
+)
+
+// notifyFlagLetters are the accepted letters. evicted (e), key miss (m) and new key (n) events
+// are never emitted, so their letters are rejected instead of being accepted silently
+var notifyFlagLetters = map[rune]int{
+	'K': notifyKeyspace,
+	'E': notifyKeyevent,
+	'g': notifyGeneric,
+	'$': notifyString,
+	'l': notifyList,
+	's': notifySet,
+	'h': notifyHash,
+	'z': notifyZSet,
+	'x': notifyExpired,
+	't': notifyStream,
+	'A': notifyAll,
+}
+
+// parseNotifyFlags parses `notify-keyspace-events` config like "KEA" or "Ex", empty string disables notifications
+func parseNotifyFlags(value string) (int, error) {
+	if value == `""` {
+		return 0, nil
+	}
+	flags := 0
+	for _, letter := range value {
+		flag, ok := notifyFlagLetters[letter]
+		if !ok {
+			return 0, errors.New("invalid notify-keyspace-events config: " + value)
+		}
+		flags |= flag
+	}
+	// either keyspace or keyevent channel must be chosen, otherwise nothing is published
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+// notify publishes keyspace and keyevent messages of the event if its class is enabled
+func (db *DB) notify(class int, event string, key string) {
+	if db.notifyFlags&class == 0 {
+		return
+	}
+	prefix := "@" + strconv.Itoa(db.index) + "__:"
+	if db.notifyFlags&notifyKeyspace != 0 {
+		db.publish("__keyspace"+prefix+key, []byte(event))
+	}
+	if db.notifyFlags&notifyKeyevent != 0 {
+		db.publish("__keyevent"+prefix+event, []byte(key))
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"go-redis/acl"
 	"go-redis/interface/resp"
 	"go-redis/resp/reply"
 )
@@ -17,5 +18,5 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() { //自动初始化
-	RegisterCommand("ping", Ping, -1)
+	RegisterCommand("ping", Ping, noPrepare, -1, acl.CatConnection)
 }
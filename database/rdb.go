@@ -0,0 +1,536 @@
+package database
+
+import (
+	"errors"
+	"go-redis/aof"
+	"go-redis/config"
+	Dict "go-redis/datastruct/dict"
+	List "go-redis/datastruct/list"
+	HashSet "go-redis/datastruct/set"
+	SortedSet "go-redis/datastruct/sortedset"
+	"go-redis/datastruct/stream"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SAVE
+// BGSAVE
+// LASTSAVE
+
+const (
+	defaultRDBFilename = "dump.rdb"
+	// saveRetryDelay is the minimal interval between automatic saves after a failed one, the same as redis
+	saveRetryDelay = 5 * time.Second
+	// saveCronInterval is the interval of checking save policies
+	saveCronInterval = time.Second
+)
+
+// errSaveInProgress is returned if a save is already running
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+// savePolicy means save if at least `changes` modifications happened in `seconds`
+type savePolicy struct {
+	seconds int64
+	changes int64
+}
+
+// parseSavePolicies parses save config like "900 1 300 10", empty string or "" disables saving
+func parseSavePolicies(value string) ([]savePolicy, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || (len(fields) == 1 && fields[0] == `""`) {
+		return nil, nil
+	}
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save config: " + value)
+	}
+	policies := make([]savePolicy, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, errors.New("invalid save config: " + value)
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, errors.New("invalid save config: " + value)
+		}
+		policies = append(policies, savePolicy{seconds: seconds, changes: changes})
+	}
+	return policies, nil
+}
+
+func rdbFilename() string {
+	if config.Properties.RDBFilename == "" {
+		return defaultRDBFilename
+	}
+	return config.Properties.RDBFilename
+}
+
+/* ---- dirty counter ---- */
+
+// addDirty records the number of modifications since the last save
+func (db *DB) addDirty(n int64) {
+	atomic.AddInt64(&db.dirty, n)
+}
+
+// dirtyCount returns the number of modifications since the last save in all dbs
+func (mdb *StandaloneDatabase) dirtyCount() int64 {
+	var dirty int64
+	for _, db := range mdb.dbSet {
+		dirty += atomic.LoadInt64(&db.dirty)
+	}
+	return dirty
+}
+
+func (mdb *StandaloneDatabase) resetDirty() {
+	for _, db := range mdb.dbSet {
+		atomic.StoreInt64(&db.dirty, 0)
+	}
+}
+
+/* ---- save ---- */
+
+// Save writes a snapshot into rdb file, it blocks until finished
+func (mdb *StandaloneDatabase) Save() error {
+	if !mdb.saving.CompareAndSwap(false, true) {
+		return errSaveInProgress
+	}
+	defer mdb.saving.Set(false)
+	return mdb.doSave(mdb.snapshot)
+}
+
+// saveLocked is SAVE executed by EXEC, which holds locks of all dbs.
+// installing a snapshot would wait for these locks forever, so keys are copied directly
+func (mdb *StandaloneDatabase) saveLocked() error {
+	if !mdb.saving.CompareAndSwap(false, true) {
+		return errSaveInProgress
+	}
+	defer mdb.saving.Set(false)
+	return mdb.doSave(mdb.copyAllLocked)
+}
+
+// BGSave writes a snapshot into rdb file in background
+func (mdb *StandaloneDatabase) BGSave() error {
+	if !mdb.saving.CompareAndSwap(false, true) {
+		return errSaveInProgress
+	}
+	mdb.saveWg.Add(1)
+	go func() {
+		defer mdb.saveWg.Done()
+		defer mdb.saving.Set(false)
+		_ = mdb.doSave(mdb.snapshot)
+	}()
+	return nil
+}
+
+// doSave dumps all dbs copied by takeSnapshot into a temp file, then renames it to rdb file,
+// caller should set saving flag
+func (mdb *StandaloneDatabase) doSave(takeSnapshot func() [][]*rdb.Object) error {
+	start := time.Now()
+	// modifications after this moment may be not included in the snapshot, so they stay dirty
+	dirtyBefore := make([]int64, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		dirtyBefore[i] = atomic.LoadInt64(&db.dirty)
+	}
+	err := writeRDBFile(rdbFilename(), takeSnapshot())
+
+	mdb.saveMu.Lock()
+	mdb.lastSaveTry = start
+	mdb.lastSaveErr = err
+	if err == nil {
+		mdb.lastSave = time.Now()
+		mdb.lastSaveCost = time.Since(start)
+	}
+	mdb.saveMu.Unlock()
+	if err != nil {
+		logger.Warn("rdb save failed: " + err.Error())
+		return err
+	}
+	for i, db := range mdb.dbSet {
+		db.addDirty(-dirtyBefore[i])
+	}
+	logger.Info("rdb saved, cost " + time.Since(start).String())
+	return nil
+}
+
+// writeRDBFile writes snapshot into a temp file in the same dir and renames it, so the rdb file is always complete
+func writeRDBFile(filename string, snapshot [][]*rdb.Object) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(filename), "*.rdb.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if err = writeRDB(file, snapshot); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// writeRDB encodes the snapshot of all dbs in rdb format
+func writeRDB(w io.Writer, snapshot [][]*rdb.Object) error {
+	enc := rdb.NewEncoder(w)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	auxs := [][2]string{
+		{"redis-ver", rdb.RedisVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, aux := range auxs {
+		if err := enc.WriteAux(aux[0], aux[1]); err != nil {
+			return err
+		}
+	}
+	for i, objs := range snapshot {
+		if len(objs) == 0 {
+			continue
+		}
+		ttlCount := 0
+		for _, obj := range objs {
+			if obj.Expiration != nil {
+				ttlCount++
+			}
+		}
+		if err := enc.WriteDBHeader(i, len(objs), ttlCount); err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := enc.WriteObject(obj); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.WriteEnd()
+}
+
+/* ---- snapshot ---- */
+
+// dbSnapshot is a copy-on-write snapshot of a db, it is a single point in time.
+// once it is installed, writing commands copy keys into it before modifying them, see preserveForSnapshot,
+// and the saver copies the other keys one by one, so writing commands are never blocked by copying the whole db
+type dbSnapshot struct {
+	now time.Time
+	mu  sync.Mutex
+	// objs holds copied keys, the object is nil if the key didn't exist or had expired when the snapshot started
+	objs map[string]*rdb.Object
+}
+
+// copyKey copies the key if it hasn't been copied, caller should hold the lock of the key
+func (snap *dbSnapshot) copyKey(db *DB, key string) {
+	snap.mu.Lock()
+	_, copied := snap.objs[key]
+	snap.mu.Unlock()
+	if copied {
+		return
+	}
+	var obj *rdb.Object
+	if raw, ok := db.data.Get(key); ok {
+		obj = db.snapshotKey(key, raw, snap.now)
+	}
+	snap.mu.Lock()
+	snap.objs[key] = obj
+	snap.mu.Unlock()
+}
+
+// preserveForSnapshot copies keys into the snapshot in progress before they are modified,
+// caller should hold locks of the keys
+func (db *DB) preserveForSnapshot(keys ...string) {
+	if len(keys) == 0 || db.snapshot == nil {
+		return
+	}
+	for _, key := range keys {
+		db.snapshot.copyKey(db, key)
+	}
+}
+
+// snapshot copies unexpired keys of all dbs into rdb objects.
+// all lock slots are held only while installing snapshots, then keys are copied one by one under their own locks
+func (mdb *StandaloneDatabase) snapshot() [][]*rdb.Object {
+	now := time.Now()
+	snaps := make([]*dbSnapshot, len(mdb.dbSet))
+	for i := range snaps {
+		snaps[i] = &dbSnapshot{
+			now:  now,
+			objs: make(map[string]*rdb.Object),
+		}
+	}
+	mdb.lockAllDBs()
+	for i, db := range mdb.dbSet {
+		db.snapshot = snaps[i]
+	}
+	mdb.unlockAllDBs()
+
+	result := make([][]*rdb.Object, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		snap := snaps[i]
+		// keys removed after the snapshot started are missing here, they have been copied by writing commands
+		for _, key := range db.data.Keys() {
+			db.locker.RLock(key)
+			snap.copyKey(db, key)
+			db.locker.RUnLock(key)
+		}
+		db.locker.LockAll()
+		db.snapshot = nil
+		db.locker.UnLockAll()
+
+		objs := make([]*rdb.Object, 0, len(snap.objs))
+		for _, obj := range snap.objs {
+			if obj != nil {
+				obj.DB = i
+				objs = append(objs, obj)
+			}
+		}
+		result[i] = objs
+	}
+	return result
+}
+
+// copyAllLocked copies unexpired keys of all dbs into rdb objects, caller should hold locks of all dbs
+func (mdb *StandaloneDatabase) copyAllLocked() [][]*rdb.Object {
+	now := time.Now()
+	result := make([][]*rdb.Object, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		objs := make([]*rdb.Object, 0, db.data.Len())
+		db.data.ForEach(func(key string, raw interface{}) bool {
+			if obj := db.snapshotKey(key, raw, now); obj != nil {
+				obj.DB = i
+				objs = append(objs, obj)
+			}
+			return true
+		})
+		result[i] = objs
+	}
+	return result
+}
+
+// lockAllDBs takes all lock slots of all dbs in order
+func (mdb *StandaloneDatabase) lockAllDBs() {
+	for _, db := range mdb.dbSet {
+		db.locker.LockAll()
+	}
+}
+
+func (mdb *StandaloneDatabase) unlockAllDBs() {
+	for i := len(mdb.dbSet) - 1; i >= 0; i-- {
+		mdb.dbSet[i].locker.UnLockAll()
+	}
+}
+
+// snapshotKey copies the given key into rdb object, it returns nil if the key is expired
+// 只持有读锁，不能调用会删除过期 key 的 GetEntity
+func (db *DB) snapshotKey(key string, raw interface{}, now time.Time) *rdb.Object {
+	expireTime, hasTTL := db.TTL(key)
+	if hasTTL && !expireTime.After(now) {
+		return nil
+	}
+	entity, _ := raw.(*database.DataEntity)
+	obj := aof.EntityToObject(key, entity)
+	if obj != nil && hasTTL {
+		obj.Expiration = &expireTime
+	}
+	return obj
+}
+
+// serveSave saves rdb in background if any save policy is satisfied
+func (mdb *StandaloneDatabase) serveSave() {
+	ticker := time.NewTicker(saveCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !mdb.saving.Get() && mdb.needSave(time.Now()) {
+				_ = mdb.BGSave()
+			}
+		case <-mdb.stopCh:
+			return
+		}
+	}
+}
+
+func (mdb *StandaloneDatabase) needSave(now time.Time) bool {
+	dirty := mdb.dirtyCount()
+	mdb.saveMu.Lock()
+	defer mdb.saveMu.Unlock()
+	// 上次保存失败时不要立刻重试
+	if mdb.lastSaveErr != nil && now.Sub(mdb.lastSaveTry) < saveRetryDelay {
+		return false
+	}
+	for _, policy := range mdb.savePolicies {
+		if dirty >= policy.changes && now.Sub(mdb.lastSave) >= time.Duration(policy.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+/* ---- load ---- */
+
+// loadRDB loads snapshot from rdb file
+func (mdb *StandaloneDatabase) loadRDB(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return mdb.LoadRDB(rdb.NewDecoder(file))
+}
+
+// LoadRDB loads all keys from rdb decoder, expired keys are skipped unless the db is loading aof
+func (mdb *StandaloneDatabase) LoadRDB(dec *rdb.Decoder) error {
+	now := time.Now()
+	return dec.Parse(func(obj *rdb.Object) bool {
+		if obj.DB < 0 || obj.DB >= len(mdb.dbSet) {
+			logger.Warn("rdb: db index " + strconv.Itoa(obj.DB) + " is out of range, key " + obj.Key + " is skipped")
+			return true
+		}
+		db := mdb.dbSet[obj.DB]
+		// 加载 aof 时不能丢弃过期 key，否则后续命令重放的结果会不一致
+		if obj.Expiration != nil && !obj.Expiration.After(now) && !db.loading.Get() {
+			return true
+		}
+		entity := objectToEntity(obj)
+		if entity == nil {
+			return true
+		}
+		db.PutEntity(obj.Key, entity)
+		if obj.Expiration != nil {
+			db.Expire(obj.Key, *obj.Expiration)
+		}
+		return true
+	})
+}
+
+// objectToEntity converts rdb object into data entity, it returns nil for unknown type
+func objectToEntity(obj *rdb.Object) *database.DataEntity {
+	switch obj.Type {
+	case rdb.StringType:
+		return &database.DataEntity{Data: obj.Value.([]byte)}
+	case rdb.ListType:
+		list := List.NewQuickList()
+		for _, value := range obj.Value.([][]byte) {
+			list.Add(value)
+		}
+		return &database.DataEntity{Data: list}
+	case rdb.HashType:
+		hash := obj.Value.(map[string][]byte)
+		// 小的 hash 使用紧凑编码，与 hashPut 的转换规则一致
+		compact := len(hash) <= hashMaxListDictEntries
+		for field, value := range hash {
+			if len(field) > hashMaxListDictValue || len(value) > hashMaxListDictValue {
+				compact = false
+				break
+			}
+		}
+		var dict Dict.Dict
+		if compact {
+			dict = Dict.MakeListDict()
+		} else {
+			dict = Dict.MakeSimple()
+		}
+		for field, value := range hash {
+			dict.Put(field, value)
+		}
+		return &database.DataEntity{Data: dict}
+	case rdb.SetType:
+		set := HashSet.Make()
+		for _, member := range obj.Value.([][]byte) {
+			set.Add(string(member))
+		}
+		return &database.DataEntity{Data: set}
+	case rdb.ZSetType:
+		zset := SortedSet.Make()
+		for _, entry := range obj.Value.([]*rdb.ZSetEntry) {
+			zset.Add(entry.Member, entry.Score)
+		}
+		return &database.DataEntity{Data: zset}
+	case rdb.StreamType:
+		value := obj.Value.(*rdb.StreamValue)
+		s := stream.Make()
+		for _, entry := range value.Entries {
+			id := stream.ID{Ms: entry.ID.Ms, Seq: entry.ID.Seq}
+			if err := s.Add(id, entry.Fields); err != nil {
+				logger.Warn("rdb: stream " + obj.Key + " has entries out of order, it is skipped")
+				return nil
+			}
+		}
+		lastID := stream.ID{Ms: value.LastID.Ms, Seq: value.LastID.Seq}
+		if s.LastID().Less(lastID) {
+			s.SetLastID(lastID)
+		}
+		return &database.DataEntity{Data: s}
+	}
+	return nil
+}
+
+// appendLoadedKeysToAof writes all keys into aof, it is used when data is loaded from rdb without aof file
+func (mdb *StandaloneDatabase) appendLoadedKeysToAof() {
+	for _, db := range mdb.dbSet {
+		db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			cmds := aof.EntityToCmds(key, entity)
+			if cmds == nil {
+				return true
+			}
+			for _, cmd := range cmds {
+				db.addAof(cmd)
+			}
+			if expiration != nil {
+				db.addAof(aof.MakeExpireCmd(key, *expiration))
+			}
+			return true
+		})
+	}
+}
+
+/* ---- commands ---- */
+
+// execSave saves rdb synchronously
+func execSave(mdb *StandaloneDatabase) resp.Reply {
+	return makeSaveReply(mdb.Save())
+}
+
+func makeSaveReply(err error) resp.Reply {
+	if err != nil {
+		if err == errSaveInProgress {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// execBGSave saves rdb in background
+func execBGSave(mdb *StandaloneDatabase) resp.Reply {
+	if err := mdb.BGSave(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+// execLastSave returns the unix time of the last successful save
+func execLastSave(mdb *StandaloneDatabase) resp.Reply {
+	mdb.saveMu.Lock()
+	defer mdb.saveMu.Unlock()
+	return reply.MakeIntReply(mdb.lastSave.Unix())
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"go-redis/acl"
+	HashSet "go-redis/datastruct/set"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strconv"
+)
+
+//SADD SREM SISMEMBER SMISMEMBER SMEMBERS SCARD SPOP SRANDMEMBER SMOVE
+//SINTER SINTERSTORE SUNION SUNIONSTORE SDIFF SDIFFSTORE
+
+func (db *DB) getAsSet(key string) (*HashSet.Set, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	set, ok := entity.Data.(*HashSet.Set)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return set, nil
+}
+
+func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply reply.ErrorReply) {
+	set, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if set == nil {
+		set = HashSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: set,
+		})
+		inited = true
+	}
+	return set, inited, nil
+}
+
+// execSAdd adds members into set
+func execSAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Add(string(member))
+	}
+	db.addAof(utils.ToCmdLine2("sadd", args...))
+	if counter > 0 {
+		db.notify(notifySet, "sadd", key)
+	}
+	return reply.MakeIntReply(int64(counter))
+}
+
+// execSIsMember checks if the given value is member of set
+func execSIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	if set.Has(member) {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execSMIsMember checks if the given values are members of set
+func execSMIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]resp.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set != nil && set.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execSRem removes a member from set
+func execSRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Remove(string(member))
+	}
+	if counter > 0 {
+		db.addAof(utils.ToCmdLine2("srem", args...))
+		db.notify(notifySet, "srem", key)
+	}
+	if set.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(int64(counter))
+}
+
+// execSPop removes one or more random members from set
+// SPOP key [count]
+func execSPop(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("spop")
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if withCount {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	// count comes from client, never pop more than the set holds
+	if count > int64(set.Len()) {
+		count = int64(set.Len())
+	}
+	members := set.RandomDistinctMembers(int(count))
+	for _, member := range members {
+		set.Remove(member)
+	}
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	if len(members) > 0 {
+		// 弹出的成员是随机的，aof 中记录确定的 srem 保证重放结果一致
+		db.addAof(utils.ToCmdLine2("srem", append([][]byte{args[0]}, result...)...))
+		db.notify(notifySet, "spop", key)
+	}
+	if set.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	if !withCount {
+		if len(result) == 0 {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(result[0])
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execSCard gets the number of members in a set
+func execSCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(set.Len()))
+}
+
+// execSMembers gets all members in a set
+func execSMembers(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return reply.MakeMultiBulkReply(setToBytes(set))
+}
+
+// execSRandMember gets random members from set
+// SRANDMEMBER key [count]
+func execSRandMember(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("srandmember")
+	}
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if set == nil {
+			return &reply.NullBulkReply{}
+		}
+		members := set.RandomMembers(1)
+		return reply.MakeBulkReply([]byte(members[0]))
+	}
+	count, errReply := parseRandomCount(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var members []string
+	if count >= 0 {
+		members = set.RandomDistinctMembers(int(count))
+	} else {
+		members = set.RandomMembers(int(-count))
+	}
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execSMove moves a member from one set to another
+func execSMove(db *DB, args [][]byte) resp.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	if src == dest {
+		// 移动到自身：什么都不做，否则移除最后一个成员时 key 会被删除
+		return reply.MakeIntReply(1)
+	}
+	srcSet.Remove(member)
+	db.notify(notifySet, "srem", src)
+	if srcSet.Len() == 0 {
+		db.removeEmpty(src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	if destSet.Add(member) > 0 {
+		db.notify(notifySet, "sadd", dest)
+	}
+	db.addAof(utils.ToCmdLine2("smove", args...))
+	return reply.MakeIntReply(1)
+}
+
+func setToBytes(set *HashSet.Set) [][]byte {
+	result := make([][]byte, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		result = append(result, []byte(member))
+		return true
+	})
+	return result
+}
+
+// setOperation computes intersect, union or diff of the given sets
+// nonexistent keys are treated as empty sets
+func setOperation(db *DB, keys [][]byte, op func(a, b *HashSet.Set) *HashSet.Set) (*HashSet.Set, reply.ErrorReply) {
+	var result *HashSet.Set
+	for i, rawKey := range keys {
+		set, errReply := db.getAsSet(string(rawKey))
+		if errReply != nil {
+			return nil, errReply
+		}
+		if set == nil {
+			set = HashSet.Make()
+		}
+		if i == 0 {
+			result = set.Union(HashSet.Make()) // copy of the first set
+			continue
+		}
+		result = op(result, set)
+	}
+	return result, nil
+}
+
+func intersect(a, b *HashSet.Set) *HashSet.Set {
+	return a.Intersect(b)
+}
+
+func union(a, b *HashSet.Set) *HashSet.Set {
+	return a.Union(b)
+}
+
+func diff(a, b *HashSet.Set) *HashSet.Set {
+	return a.Diff(b)
+}
+
+// setOperationCmd implements SINTER, SUNION and SDIFF
+func setOperationCmd(db *DB, keys [][]byte, op func(a, b *HashSet.Set) *HashSet.Set) resp.Reply {
+	result, errReply := setOperation(db, keys, op)
+	if errReply != nil {
+		return errReply
+	}
+	if result.Len() == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return reply.MakeMultiBulkReply(setToBytes(result))
+}
+
+// setOperationStoreCmd implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+func setOperationStoreCmd(db *DB, cmdName string, args [][]byte, op func(a, b *HashSet.Set) *HashSet.Set) resp.Reply {
+	dest := string(args[0])
+	result, errReply := setOperation(db, args[1:], op)
+	if errReply != nil {
+		return errReply
+	}
+	_, existed := db.GetEntity(dest)
+	db.Remove(dest)
+	if result.Len() > 0 {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.notify(notifySet, cmdName, dest)
+	} else if existed {
+		db.notify(notifyGeneric, "del", dest)
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(result.Len()))
+}
+
+// execSInter intersect multiple sets
+func execSInter(db *DB, args [][]byte) resp.Reply {
+	return setOperationCmd(db, args, intersect)
+}
+
+// execSInterStore intersects multiple sets and store the result in a key
+func execSInterStore(db *DB, args [][]byte) resp.Reply {
+	return setOperationStoreCmd(db, "sinterstore", args, intersect)
+}
+
+// execSUnion adds multiple sets
+func execSUnion(db *DB, args [][]byte) resp.Reply {
+	return setOperationCmd(db, args, union)
+}
+
+// execSUnionStore adds multiple sets and store the result in a key
+func execSUnionStore(db *DB, args [][]byte) resp.Reply {
+	return setOperationStoreCmd(db, "sunionstore", args, union)
+}
+
+// execSDiff subtracts multiple sets
+func execSDiff(db *DB, args [][]byte) resp.Reply {
+	return setOperationCmd(db, args, diff)
+}
+
+// execSDiffStore subtracts multiple sets and store the result in a key
+func execSDiffStore(db *DB, args [][]byte) resp.Reply {
+	return setOperationStoreCmd(db, "sdiffstore", args, diff)
+}
+
+// execSScan iterates members of set
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+	members, nextCursor := set.SetScan(scan.cursor, scan.count, scan.pattern)
+	return makeScanReply(nextCursor, members)
+}
+
+func init() {
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, -3, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, 3, acl.CatRead, acl.CatSet)
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, -3, acl.CatRead, acl.CatSet)
+	RegisterCommand("SRem", execSRem, writeFirstKey, -3, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SPop", execSPop, writeFirstKey, -2, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SCard", execSCard, readFirstKey, 2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SMembers", execSMembers, readFirstKey, 2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, -2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SMove", execSMove, writeFirstTwoKeys, 4, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SInter", execSInter, readAllKeys, -2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SInterStore", execSInterStore, prepareStore, -3, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SUnion", execSUnion, readAllKeys, -2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SUnionStore", execSUnionStore, prepareStore, -3, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SDiff", execSDiff, readAllKeys, -2, acl.CatRead, acl.CatSet)
+	RegisterCommand("SDiffStore", execSDiffStore, prepareStore, -3, acl.CatWrite, acl.CatSet)
+	RegisterCommand("SScan", execSScan, readFirstKey, -3, acl.CatRead, acl.CatSet)
+}
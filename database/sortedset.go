@@ -0,0 +1,922 @@
+package database
+
+import (
+	"go-redis/acl"
+	HashSet "go-redis/datastruct/set"
+	SortedSet "go-redis/datastruct/sortedset"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//ZADD ZSCORE ZMSCORE ZINCRBY ZCARD ZRANK ZREVRANK ZCOUNT ZLEXCOUNT
+//ZRANGE ZREVRANGE ZRANGEBYSCORE ZREVRANGEBYSCORE ZRANGEBYLEX ZREVRANGEBYLEX
+//ZREM ZREMRANGEBYSCORE ZREMRANGEBYRANK ZREMRANGEBYLEX ZPOPMIN ZPOPMAX BZPOPMIN
+//ZUNIONSTORE ZINTERSTORE
+//ZSCAN
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, inited bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: sortedSet,
+		})
+		inited = true
+	}
+	return sortedSet, inited, nil
+}
+
+// parseScore parses score argument, NaN is not allowed
+func parseScore(arg []byte) (float64, reply.ErrorReply) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+// formatScore formats score like redis, e.g. 1, 1.5, inf, -inf
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'g', -1, 64))
+}
+
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddIncr
+)
+
+// execZAdd adds members into sorted set
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	flags := 0
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			flags |= zaddCH
+		case "INCR":
+			flags |= zaddIncr
+		default:
+			goto parseElements
+		}
+	}
+parseElements:
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags&zaddGT != 0 && flags&zaddLT != 0) || (flags&zaddNX != 0 && flags&(zaddGT|zaddLT) != 0) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags&zaddIncr != 0 && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	elements := make([]*SortedSet.Element, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, errReply := parseScore(pairs[j])
+		if errReply != nil {
+			return errReply
+		}
+		elements[j/2] = &SortedSet.Element{
+			Member: string(pairs[j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if flags&zaddXX != 0 {
+			if flags&zaddIncr != 0 {
+				return &reply.NullBulkReply{}
+			}
+			return reply.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	added, changed := 0, 0
+	var incrResult *float64
+	for _, e := range elements {
+		old, exists := sortedSet.Get(e.Member)
+		if (exists && flags&zaddNX != 0) || (!exists && flags&zaddXX != 0) {
+			continue
+		}
+		score := e.Score
+		if flags&zaddIncr != 0 && exists {
+			score += old.Score
+			if math.IsNaN(score) {
+				return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && ((flags&zaddGT != 0 && score <= old.Score) || (flags&zaddLT != 0 && score >= old.Score)) {
+			continue
+		}
+		if !exists {
+			added++
+			changed++
+		} else if score != old.Score {
+			changed++
+		}
+		sortedSet.Add(e.Member, score)
+		incrResult = &score
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+
+	if flags&zaddIncr != 0 {
+		if incrResult == nil {
+			return &reply.NullBulkReply{}
+		}
+		// 浮点累加的结果直接写入 aof
+		db.addAof(utils.ToCmdLine2("zadd", args[0], formatScore(*incrResult), pairs[1]))
+		db.notify(notifyZSet, "zincr", key)
+		return reply.MakeBulkReply(formatScore(*incrResult))
+	}
+	if changed > 0 {
+		db.addAof(utils.ToCmdLine2("zadd", args...))
+		db.notify(notifyZSet, "zadd", key)
+	}
+	if flags&zaddCH != 0 {
+		return reply.MakeIntReply(int64(changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// execZIncrBy increments the score of a member
+func execZIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, errReply := parseScore(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	score := delta
+	if element, exists := sortedSet.Get(member); exists {
+		score += element.Score
+	}
+	if math.IsNaN(score) {
+		return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+	}
+	sortedSet.Add(member, score)
+	db.addAof(utils.ToCmdLine2("zadd", args[0], formatScore(score), args[2]))
+	db.notify(notifyZSet, "zincr", key)
+	return reply.MakeBulkReply(formatScore(score))
+}
+
+// execZScore gets score of a member in sorted set
+func execZScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+// execZMScore gets scores of members in sorted set
+func execZMScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, member := range args[1:] {
+		if element, exists := sortedSet.Get(string(member)); exists {
+			result[i] = formatScore(element.Score)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execZCard gets number of members in sorted set
+func execZCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+func rankGeneric(db *DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// execZRank gets index of a member in sortedset, ascending order, start from 0
+func execZRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank gets index of a member in sortedset, descending order, start from 0
+func execZRevRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, true)
+}
+
+func countGeneric(db *DB, args [][]byte, parseBorder func(string) (SortedSet.Border, error)) resp.Reply {
+	key := string(args[0])
+	min, err := parseBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// execZCount gets number of members which score within given range
+func execZCount(db *DB, args [][]byte) resp.Reply {
+	return countGeneric(db, args, SortedSet.ParseScoreBorder)
+}
+
+// execZLexCount gets number of members which member within given lex range
+func execZLexCount(db *DB, args [][]byte) resp.Reply {
+	return countGeneric(db, args, SortedSet.ParseLexBorder)
+}
+
+func elementsToReply(elements []*SortedSet.Element, withScores bool) resp.Reply {
+	if len(elements) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	result := make([][]byte, 0, len(elements)*2)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// rangeByRank returns members which rank within [start, stop], negative index is allowed
+func rangeByRank(db *DB, key string, start int64, stop int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	from, to := normalizeRange(start, stop, sortedSet.Len())
+	if from >= to {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	elements := sortedSet.RangeByRank(int64(from), int64(to), desc)
+	return elementsToReply(elements, withScores)
+}
+
+// rangeByBorder returns members which score or member within the given border
+func rangeByBorder(db *DB, key string, min SortedSet.Border, max SortedSet.Border,
+	offset int64, limit int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	elements := sortedSet.Range(min, max, offset, limit, desc)
+	return elementsToReply(elements, withScores)
+}
+
+const (
+	rangeByRankType = iota
+	rangeByScoreType
+	rangeByLexType
+)
+
+// zrangeOptions is parsed arguments of ZRANGE family
+type zrangeOptions struct {
+	rangeType  int
+	desc       bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	limit      int64
+}
+
+// parseRangeOptions parses [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func parseRangeOptions(args [][]byte, opts *zrangeOptions, allowType bool) reply.ErrorReply {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			if !allowType {
+				return &reply.SyntaxErrReply{}
+			}
+			opts.rangeType = rangeByScoreType
+		case "BYLEX":
+			if !allowType {
+				return &reply.SyntaxErrReply{}
+			}
+			opts.rangeType = rangeByLexType
+		case "REV":
+			if !allowType {
+				return &reply.SyntaxErrReply{}
+			}
+			opts.desc = true
+		case "WITHSCORES":
+			opts.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limit, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			opts.hasLimit = true
+			opts.offset = offset
+			opts.limit = limit
+			i += 2
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	if opts.hasLimit && opts.rangeType == rangeByRankType {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if opts.withScores && opts.rangeType == rangeByLexType {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// zrangeGeneric executes range query, `start` and `stop` are given in the order of the command arguments
+func zrangeGeneric(db *DB, key string, start []byte, stop []byte, opts *zrangeOptions) resp.Reply {
+	if opts.rangeType == rangeByRankType {
+		startIdx, err := strconv.ParseInt(string(start), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stopIdx, err := strconv.ParseInt(string(stop), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		return rangeByRank(db, key, startIdx, stopIdx, opts.withScores, opts.desc)
+	}
+
+	parseBorder := SortedSet.ParseScoreBorder
+	if opts.rangeType == rangeByLexType {
+		parseBorder = SortedSet.ParseLexBorder
+	}
+	// in reversed order, the first argument is the max border
+	minArg, maxArg := start, stop
+	if opts.desc {
+		minArg, maxArg = stop, start
+	}
+	min, err := parseBorder(string(minArg))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(maxArg))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	offset, limit := int64(0), int64(-1)
+	if opts.hasLimit {
+		offset, limit = opts.offset, opts.limit
+	}
+	return rangeByBorder(db, key, min, max, offset, limit, opts.withScores, opts.desc)
+}
+
+// execZRange gets members in range
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) resp.Reply {
+	opts := &zrangeOptions{}
+	if errReply := parseRangeOptions(args[3:], opts, true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), args[1], args[2], opts)
+}
+
+// execZRevRange gets members in range, sort by descending order
+func execZRevRange(db *DB, args [][]byte) resp.Reply {
+	opts := &zrangeOptions{desc: true}
+	if errReply := parseRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), args[1], args[2], opts)
+}
+
+func legacyRangeGeneric(db *DB, args [][]byte, rangeType int, desc bool) resp.Reply {
+	opts := &zrangeOptions{
+		rangeType: rangeType,
+		desc:      desc,
+	}
+	if errReply := parseRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, string(args[0]), args[1], args[2], opts)
+}
+
+// execZRangeByScore gets members which score within given range, in ascending order
+func execZRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return legacyRangeGeneric(db, args, rangeByScoreType, false)
+}
+
+// execZRevRangeByScore gets number of members which score within given range, in descending order
+func execZRevRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return legacyRangeGeneric(db, args, rangeByScoreType, true)
+}
+
+// execZRangeByLex gets members which member within given lex range, in ascending order
+func execZRangeByLex(db *DB, args [][]byte) resp.Reply {
+	return legacyRangeGeneric(db, args, rangeByLexType, false)
+}
+
+// execZRevRangeByLex gets members which member within given lex range, in descending order
+func execZRevRangeByLex(db *DB, args [][]byte) resp.Reply {
+	return legacyRangeGeneric(db, args, rangeByLexType, true)
+}
+
+// execZRem removes given members
+func execZRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	var deleted int64 = 0
+	for _, field := range args[1:] {
+		if sortedSet.Remove(string(field)) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("zrem", args...))
+		db.notify(notifyZSet, "zrem", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+func removeRangeGeneric(db *DB, cmdName string, args [][]byte, parseBorder func(string) (SortedSet.Border, error)) resp.Reply {
+	key := string(args[0])
+	min, err := parseBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveRange(min, max)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+		db.notify(notifyZSet, cmdName, key)
+	}
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// execZRemRangeByScore removes members which score within given range
+func execZRemRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return removeRangeGeneric(db, "zremrangebyscore", args, SortedSet.ParseScoreBorder)
+}
+
+// execZRemRangeByLex removes members which member within given lex range
+func execZRemRangeByLex(db *DB, args [][]byte) resp.Reply {
+	return removeRangeGeneric(db, "zremrangebylex", args, SortedSet.ParseLexBorder)
+}
+
+// execZRemRangeByRank removes members within given indexes
+func execZRemRangeByRank(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	from, to := normalizeRange(start, stop, sortedSet.Len())
+	if from >= to {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(int64(from), int64(to))
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("zremrangebyrank", args...))
+		db.notify(notifyZSet, "zremrangebyrank", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func popGenericZSet(db *DB, cmdName string, args [][]byte, max bool) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	key := string(args[0])
+	count := int64(1)
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(int(count))
+	} else {
+		removed = sortedSet.PopMin(int(count))
+	}
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+		db.notify(notifyZSet, cmdName, key)
+	}
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return elementsToReply(removed, true)
+}
+
+// execZPopMin removes and returns members with the lowest scores
+func execZPopMin(db *DB, args [][]byte) resp.Reply {
+	return popGenericZSet(db, "zpopmin", args, false)
+}
+
+// execZPopMax removes and returns members with the highest scores
+func execZPopMax(db *DB, args [][]byte) resp.Reply {
+	return popGenericZSet(db, "zpopmax", args, true)
+}
+
+// execBZPopMin pops the member with the lowest score from the first non-empty sorted set, blocks if all are empty
+// BZPOPMIN key [key ...] timeout, aof records the equivalent ZPOPMIN
+func execBZPopMin(db *DB, args [][]byte) resp.Reply {
+	if _, errReply := parseBlockTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			continue
+		}
+		removed := sortedSet.PopMin(1)
+		db.addAof(utils.ToCmdLine2("zpopmin", arg))
+		db.notify(notifyZSet, "zpopmin", key)
+		if sortedSet.Len() == 0 {
+			db.removeEmpty(key)
+		}
+		return reply.MakeMultiBulkReply([][]byte{arg, []byte(removed[0].Member), formatScore(removed[0].Score)})
+	}
+	return reply.MakeNullMultiBulkReply()
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// weightScore multiplies score by weight, like redis 0 * inf is 0 rather than NaN
+func weightScore(score, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+func aggregate(a, b float64, aggregateType int) float64 {
+	switch aggregateType {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	result := a + b
+	if math.IsNaN(result) { // inf + -inf
+		return 0
+	}
+	return result
+}
+
+// getAsWeightedElements reads a sorted set or a set (whose members' score are 1) as elements
+func (db *DB) getAsWeightedElements(key string) (map[string]float64, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	result := make(map[string]float64)
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		data.ForEachByRank(0, data.Len(), false, func(element *SortedSet.Element) bool {
+			result[element.Member] = element.Score
+			return true
+		})
+	case *HashSet.Set:
+		data.ForEach(func(member string) bool {
+			result[member] = 1
+			return true
+		})
+	default:
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return result, nil
+}
+
+// storeGeneric implements ZUNIONSTORE and ZINTERSTORE
+// destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE <SUM | MIN | MAX>]
+func storeGeneric(db *DB, cmdName string, args [][]byte, isUnion bool) resp.Reply {
+	dest := string(args[0])
+	numKeys, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if int64(len(args)-2) < numKeys {
+		return &reply.SyntaxErrReply{}
+	}
+	keys := args[2 : 2+numKeys]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregateType := aggregateSum
+	for i := 2 + int(numKeys); i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WEIGHTS":
+			if i+int(numKeys) >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			for j := 0; j < int(numKeys); j++ {
+				weight, errReply := parseScore(args[i+1+j])
+				if errReply != nil {
+					return reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "SUM":
+				aggregateType = aggregateSum
+			case "MIN":
+				aggregateType = aggregateMin
+			case "MAX":
+				aggregateType = aggregateMax
+			default:
+				return &reply.SyntaxErrReply{}
+			}
+			i++
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	inputs := make([]map[string]float64, numKeys)
+	for i, key := range keys {
+		elements, errReply := db.getAsWeightedElements(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		inputs[i] = elements
+	}
+
+	result := SortedSet.Make()
+	if isUnion {
+		scores := make(map[string]float64)
+		for i, elements := range inputs {
+			for member, score := range elements {
+				weighted := weightScore(score, weights[i])
+				if old, ok := scores[member]; ok {
+					scores[member] = aggregate(old, weighted, aggregateType)
+				} else {
+					scores[member] = weighted
+				}
+			}
+		}
+		for member, score := range scores {
+			result.Add(member, score)
+		}
+	} else if inputs[0] != nil {
+	members:
+		for member, score := range inputs[0] {
+			acc := weightScore(score, weights[0])
+			for i := 1; i < len(inputs); i++ {
+				other, ok := inputs[i][member]
+				if !ok {
+					continue members
+				}
+				acc = aggregate(acc, weightScore(other, weights[i]), aggregateType)
+			}
+			result.Add(member, acc)
+		}
+	}
+
+	_, existed := db.GetEntity(dest)
+	db.Remove(dest)
+	if result.Len() > 0 {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.notify(notifyZSet, cmdName, dest)
+	} else if existed {
+		db.notify(notifyGeneric, "del", dest)
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(result.Len())
+}
+
+// execZUnionStore computes union of sorted sets and stores the result in destination
+func execZUnionStore(db *DB, args [][]byte) resp.Reply {
+	return storeGeneric(db, "zunionstore", args, true)
+}
+
+// execZInterStore computes intersection of sorted sets and stores the result in destination
+func execZInterStore(db *DB, args [][]byte) resp.Reply {
+	return storeGeneric(db, "zinterstore", args, false)
+}
+
+// execZScan iterates members and scores of sorted set
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func execZScan(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+	elements, nextCursor := sortedSet.Scan(scan.cursor, scan.count, scan.pattern)
+	result := make([][]byte, 0, 2*len(elements))
+	for _, element := range elements {
+		result = append(result, []byte(element.Member), formatScore(element.Score))
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, -4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, 4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZScore", execZScore, readFirstKey, 3, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZMScore", execZMScore, readFirstKey, -3, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZCard", execZCard, readFirstKey, 2, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRank", execZRank, readFirstKey, 3, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, 3, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZCount", execZCount, readFirstKey, 4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, 4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRange", execZRange, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRangeByLex", execZRangeByLex, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, -4, acl.CatRead, acl.CatSortedSet)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, -3, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, 4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, 4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, 4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, -2, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, -2, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("BZPopMin", execBZPopMin, prepareBlockingPop, -3, acl.CatWrite, acl.CatSortedSet, acl.CatBlocking)
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareZStore, -4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZInterStore", execZInterStore, prepareZStore, -4, acl.CatWrite, acl.CatSortedSet)
+	RegisterCommand("ZScan", execZScan, readFirstKey, -3, acl.CatRead, acl.CatSortedSet)
+}
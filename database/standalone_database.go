@@ -4,34 +4,70 @@ import (
 	"fmt"
 	"go-redis/aof"
 	"go-redis/config"
+	"go-redis/interface/database"
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
+	"go-redis/lib/sync/atomic"
+	"go-redis/pubsub"
 	"go-redis/resp/reply"
+	"os"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // StandaloneDatabase is a set of multiple database set
 type StandaloneDatabase struct {
 	dbSet      []*DB
 	aofHandler *aof.AofHandler
+	hub        *pubsub.Hub   // subscribers of channels, shared by all db
+	stopCh     chan struct{} // 关闭后台任务（主动过期、自动保存）
+	closeOnce  sync.Once
+
+	// savePolicies is parsed from `save` config, no automatic save if it is empty
+	savePolicies []savePolicy
+	// saving is true while SAVE or BGSAVE is in progress
+	saving atomic.Boolean
+	// saveWg waits for background saving before shutdown
+	saveWg sync.WaitGroup
+	// saveMu protects fields below
+	saveMu       sync.Mutex
+	lastSave     time.Time // time of the last successful save
+	lastSaveTry  time.Time
+	lastSaveErr  error // nil if the last save succeeded
+	lastSaveCost time.Duration
 }
 
+// activeExpireInterval is the interval of active expiring, the same as redis default hz 10
+const activeExpireInterval = 100 * time.Millisecond
+
 // NewStandaloneDatabase creates a redis database,
 func NewStandaloneDatabase() *StandaloneDatabase {
-	mdb := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
+	mdb := newBasicDatabase()
+	policies, err := parseSavePolicies(config.Properties.Save)
+	if err != nil {
+		logger.Warn(err)
 	}
-	mdb.dbSet = make([]*DB, config.Properties.Databases)
-	for i := range mdb.dbSet {
-		singleDB := makeDB()
-		singleDB.index = i
-		mdb.dbSet[i] = singleDB
+	mdb.savePolicies = policies
+	// 优先从 aof 恢复，没有 aof 文件时才加载 rdb
+	aofExists := false
+	if config.Properties.AppendOnly {
+		aofExists = aof.Exists()
+	}
+	if !aofExists {
+		err := mdb.loadRDB(rdbFilename())
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error("load rdb failed: " + err.Error())
+		}
 	}
 	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAOFHandler(mdb)
+		mdb.setLoading(true)
+		aofHandler, err := aof.NewAOFHandler(mdb, func() database.DBEngine {
+			return newTempDatabase()
+		})
+		mdb.setLoading(false)
 		if err != nil {
 			panic(err)
 		}
@@ -44,10 +80,87 @@ func NewStandaloneDatabase() *StandaloneDatabase {
 				mdb.aofHandler.AddAof(singleDB.index, line)
 			}
 		}
+		if !aofExists {
+			// keys loaded from rdb must be in the new aof file, otherwise they are lost at next startup
+			mdb.appendLoadedKeysToAof()
+		}
+	}
+	// enabled after loading, replayed commands are not notified
+	notifyFlags, err := parseNotifyFlags(config.Properties.NotifyKeyspaceEvents)
+	if err != nil {
+		logger.Warn(err)
+	}
+	for _, db := range mdb.dbSet {
+		db.notifyFlags = notifyFlags
+		db.publish = func(channel string, message []byte) {
+			mdb.hub.Publish(channel, message)
+		}
+	}
+	mdb.resetDirty()
+	go mdb.serveActiveExpire()
+	if len(mdb.savePolicies) > 0 {
+		go mdb.serveSave()
+	}
+	return mdb
+}
+
+// newBasicDatabase creates databases without aof and background tasks
+func newBasicDatabase() *StandaloneDatabase {
+	mdb := &StandaloneDatabase{
+		hub:      pubsub.MakeHub(),
+		stopCh:   make(chan struct{}),
+		lastSave: time.Now(),
+	}
+	if config.Properties.Databases == 0 {
+		config.Properties.Databases = 16
+	}
+	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	for i := range mdb.dbSet {
+		singleDB := makeDB()
+		singleDB.index = i
+		mdb.dbSet[i] = singleDB
 	}
 	return mdb
 }
 
+// newTempDatabase creates databases to replay aof when rewriting, keys in it never expire
+func newTempDatabase() *StandaloneDatabase {
+	mdb := newBasicDatabase()
+	mdb.setLoading(true)
+	return mdb
+}
+
+// ForEach traverses all keys in the given db
+func (mdb *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	if dbIndex < 0 || dbIndex >= len(mdb.dbSet) {
+		return
+	}
+	mdb.dbSet[dbIndex].ForEach(cb)
+}
+
+// setLoading marks all db as loading (or not), keys won't expire during loading
+func (mdb *StandaloneDatabase) setLoading(loading bool) {
+	for _, db := range mdb.dbSet {
+		db.loading.Set(loading)
+	}
+}
+
+// serveActiveExpire removes expired keys periodically, so keys never accessed again won't leak
+func (mdb *StandaloneDatabase) serveActiveExpire() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, db := range mdb.dbSet {
+				db.activeExpireCycle()
+			}
+		case <-mdb.stopCh:
+			return
+		}
+	}
+}
+
 // Exec executes command
 // parameter `cmdLine` contains command and its arguments, for example: "set key value"
 // set k v         get k        select 2  等等很多命令 只有select 整个命令在此层做
@@ -60,27 +173,207 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	if cmdName == "select" {
-		if len(cmdLine) != 2 {
-			return reply.MakeArgNumErrReply("select")
+	if cmdName == "auth" {
+		return Auth(c, cmdLine[1:])
+	}
+	if !IsAuthenticated(c) {
+		return MakeNoAuthErrReply()
+	}
+	if _, ok := cmdTable[cmdName]; !ok {
+		// normal commands are checked by DB.Exec
+		if errReply := CheckPermission(c, cmdLine); errReply != nil {
+			return errReply
 		}
-		return execSelect(c, mdb, cmdLine[1:])
 	}
-	// normal commands
+	if errReply := pubsub.CheckSubscribeMode(c, cmdName); errReply != nil {
+		return errReply
+	}
+	if cmdName == "ping" && c.SubsCount() > 0 {
+		return pubsub.Ping(cmdLine[1:])
+	}
 	dbIndex := c.GetDBIndex()
 	if dbIndex >= len(mdb.dbSet) {
 		return reply.MakeErrReply("ERR DB index is out of range")
 	}
 	selectedDB := mdb.dbSet[dbIndex]
+	// 事务控制命令需要操作连接的状态，不能进入事务队列
+	switch cmdName {
+	case "select":
+		if len(cmdLine) != 2 {
+			return reply.MakeArgNumErrReply("select")
+		}
+		if c.InMultiState() {
+			return reply.MakeErrReply("ERR cannot select database within multi")
+		}
+		return execSelect(c, mdb, cmdLine[1:])
+	case "multi":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(c)
+	case "discard":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(mdb, c)
+	case "exec":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(mdb, selectedDB, c)
+	case "watch":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if c.InMultiState() {
+			return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+		}
+		return Watch(selectedDB, c, cmdLine[1:])
+	case "unwatch":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(mdb, c)
+	}
+	if _, ok := serverCommands[cmdName]; ok {
+		if c.InMultiState() {
+			return EnqueueCmd(c, cmdLine)
+		}
+		return mdb.execServerCommand(c, cmdLine)
+	}
+	// normal commands
 	return selectedDB.Exec(c, cmdLine)
 }
 
+// serverCommand is a command executed by StandaloneDatabase instead of a DB, since it doesn't operate keys
+type serverCommand struct {
+	executor func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply
+	arity    int // same as command.arity
+}
+
+// serverCommands are queued within MULTI like normal commands and executed by EXEC in order
+var serverCommands = map[string]*serverCommand{
+	"subscribe": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.Subscribe(mdb.hub, c, args)
+		},
+		arity: -2,
+	},
+	"psubscribe": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.PSubscribe(mdb.hub, c, args)
+		},
+		arity: -2,
+	},
+	"unsubscribe": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.UnSubscribe(mdb.hub, c, args)
+		},
+		arity: -1,
+	},
+	"punsubscribe": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.PUnSubscribe(mdb.hub, c, args)
+		},
+		arity: -1,
+	},
+	"publish": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.Publish(mdb.hub, args)
+		},
+		arity: 3,
+	},
+	"pubsub": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return pubsub.PubSub(mdb.hub, args)
+		},
+		arity: -2,
+	},
+	"acl": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execACL(c, args)
+		},
+		arity: -2,
+	},
+	"info": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execInfo(mdb, args)
+		},
+		arity: -1,
+	},
+	"bgrewriteaof": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execBGRewriteAOF(mdb)
+		},
+		arity: 1,
+	},
+	"save": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execSave(mdb)
+		},
+		arity: 1,
+	},
+	"bgsave": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execBGSave(mdb)
+		},
+		arity: 1,
+	},
+	"lastsave": {
+		executor: func(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+			return execLastSave(mdb)
+		},
+		arity: 1,
+	},
+}
+
+// execServerCommand executes a command in serverCommands, queued ones are executed by EXEC through it too
+func (mdb *StandaloneDatabase) execServerCommand(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd := serverCommands[cmdName]
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	return cmd.executor(mdb, c, cmdLine[1:])
+}
+
 // Close graceful shutdown database
-func (mdb *StandaloneDatabase) Close() { // 没有什么特殊的逻辑
+// it may be called more than once, later calls wait until the first one finishes
+func (mdb *StandaloneDatabase) Close() {
+	mdb.closeOnce.Do(func() {
+		close(mdb.stopCh)
+		mdb.saveWg.Wait()
+		if len(mdb.savePolicies) > 0 {
+			// 与 redis 一致：配置了 save 策略时，关闭前保存一次
+			_ = mdb.Save()
+		}
+		if mdb.aofHandler != nil {
+			mdb.aofHandler.Close()
+		}
+	})
+}
 
+func (mdb *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+	mdb.hub.UnsubscribeAll(c)
+	mdb.unwatchAll(c)
+	// a blocked client leaves the queue itself when woken by closing, this is a safety net
+	for _, db := range mdb.dbSet {
+		db.leaveConn(c)
+	}
 }
 
-func (mdb *StandaloneDatabase) AfterClientClose(c resp.Connection) { // 没有什么特殊的逻辑
+// execBGRewriteAOF rewrites aof in background
+func execBGRewriteAOF(mdb *StandaloneDatabase) resp.Reply {
+	if mdb.aofHandler == nil {
+		return reply.MakeErrReply("ERR AOF is not enabled")
+	}
+	if mdb.aofHandler.IsRewriting() {
+		return reply.MakeErrReply(aof.ErrRewriteInProgress.Error())
+	}
+	go func() {
+		_ = mdb.aofHandler.Rewrite()
+	}()
+	return reply.MakeStatusReply("Background append only file rewriting started")
 }
 
 // select 2
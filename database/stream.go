@@ -0,0 +1,548 @@
+package database
+
+import (
+	"go-redis/acl"
+	"go-redis/datastruct/stream"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//XADD XTRIM XDEL XSETID XLEN
+//XRANGE XREVRANGE XREAD
+
+var invalidStreamIDErrReply = reply.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+
+func (db *DB) getAsStream(key string) (*stream.Stream, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+// parseStreamID parses id argument like `ms-seq`, defaultSeq is used if the sequence number is omitted
+func parseStreamID(arg []byte, defaultSeq uint64) (stream.ID, reply.ErrorReply) {
+	id, err := stream.ParseID(string(arg), defaultSeq)
+	if err != nil {
+		return stream.ID{}, invalidStreamIDErrReply
+	}
+	return id, nil
+}
+
+// parseRangeID parses start or end of XRANGE, which may be `-`, `+`, an incomplete id or an exclusive id like `(1-1`
+// it returns false if the interval is empty, e.g. exclusive start is the max id
+func parseRangeID(arg []byte, isStart bool) (stream.ID, bool, reply.ErrorReply) {
+	switch string(arg) {
+	case "-":
+		return stream.MinID, true, nil
+	case "+":
+		return stream.MaxID, true, nil
+	}
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	defaultSeq := uint64(0)
+	if !isStart {
+		defaultSeq = stream.MaxID.Seq
+	}
+	id, errReply := parseStreamID(arg, defaultSeq)
+	if errReply != nil {
+		return stream.ID{}, false, errReply
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	var ok bool
+	if isStart {
+		id, ok = id.Next()
+	} else {
+		id, ok = id.Prev()
+	}
+	return id, ok, nil
+}
+
+// streamTrim is the trimming option: MAXLEN|MINID [=|~] threshold [LIMIT count]
+type streamTrim struct {
+	byMinID bool
+	maxLen  int
+	minID   stream.ID
+	limit   int // 0 means no limit
+}
+
+// parseStreamTrim parses trimming option starting at args[i], it returns the index after the option.
+// entries are always trimmed exactly, `~` only allows LIMIT
+func parseStreamTrim(args [][]byte, i int) (*streamTrim, int, reply.ErrorReply) {
+	trim := &streamTrim{
+		byMinID: strings.ToUpper(string(args[i])) == "MINID",
+	}
+	i++
+	approx := false
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, &reply.SyntaxErrReply{}
+	}
+	if trim.byMinID {
+		id, errReply := parseStreamID(args[i], 0)
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		trim.minID = id
+	} else {
+		maxLen, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return nil, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = int(maxLen)
+	}
+	i++
+	if i < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		if !approx {
+			return nil, 0, reply.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		if i+1 >= len(args) {
+			return nil, 0, &reply.SyntaxErrReply{}
+		}
+		limit, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return nil, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return nil, 0, reply.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = int(limit)
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// apply trims the stream, it returns the number of removed entries
+func (trim *streamTrim) apply(s *stream.Stream) int {
+	if trim.byMinID {
+		return s.TrimMinID(trim.minID, trim.limit)
+	}
+	return s.TrimMaxLen(trim.maxLen, trim.limit)
+}
+
+func entriesToReply(entries []*stream.Entry) resp.Reply {
+	replies := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(entry.ID.String())),
+			reply.MakeMultiBulkReply(entry.Fields),
+		})
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execXAdd appends an entry to stream, the key is created if not exists
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+// aof records the generated id, so replaying reproduces the same ids
+func execXAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NOMKSTREAM" {
+			noMkStream = true
+		} else if (option == "MAXLEN" || option == "MINID") && trim == nil {
+			var errReply reply.ErrorReply
+			trim, i, errReply = parseStreamTrim(args, i)
+			if errReply != nil {
+				return errReply
+			}
+			i-- // i is the index after trimming option
+		} else {
+			break
+		}
+	}
+	idIndex := i
+	fields := args[idIndex+1:]
+	if idIndex >= len(args) || len(fields) == 0 || len(fields)%2 != 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	created := false
+	if s == nil {
+		if noMkStream {
+			return &reply.NullBulkReply{}
+		}
+		s = stream.Make()
+		created = true
+	}
+	var id stream.ID
+	var err error
+	rawID := string(args[idIndex])
+	if rawID == "*" {
+		id, err = s.NextID(uint64(time.Now().UnixNano() / int64(time.Millisecond)))
+	} else if strings.HasSuffix(rawID, "-*") {
+		var ms uint64
+		ms, err = strconv.ParseUint(strings.TrimSuffix(rawID, "-*"), 10, 64)
+		if err != nil {
+			return invalidStreamIDErrReply
+		}
+		id, err = s.NextSeqID(ms)
+	} else {
+		id, errReply = parseStreamID(args[idIndex], 0)
+		if errReply != nil {
+			return errReply
+		}
+		if id == stream.MinID {
+			return reply.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+		}
+	}
+	if err == stream.ErrIDExhausted {
+		return reply.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+	}
+	if err == nil {
+		err = s.Add(id, fields)
+	}
+	if err != nil {
+		return reply.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	if created {
+		db.PutEntity(key, &database.DataEntity{Data: s})
+	}
+	trimmed := 0
+	if trim != nil {
+		trimmed = trim.apply(s)
+	}
+
+	cmdLine := utils.ToCmdLine2("xadd", args...)
+	cmdLine[idIndex+1] = []byte(id.String())
+	db.addAof(cmdLine)
+	db.notify(notifyStream, "xadd", key)
+	if trimmed > 0 {
+		db.notify(notifyStream, "xtrim", key)
+	}
+	return reply.MakeBulkReply([]byte(id.String()))
+}
+
+// execXTrim removes the oldest entries, XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	option := strings.ToUpper(string(args[1]))
+	if option != "MAXLEN" && option != "MINID" {
+		return &reply.SyntaxErrReply{}
+	}
+	trim, next, errReply := parseStreamTrim(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	if next != len(args) {
+		return &reply.SyntaxErrReply{}
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := trim.apply(s)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("xtrim", args...))
+		db.notify(notifyStream, "xtrim", key)
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execXDel removes entries by id, XDEL key id [id ...]
+// the stream is kept even if it becomes empty, like redis
+func execXDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ids := make([]stream.ID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, errReply := parseStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("xdel", args...))
+		db.notify(notifyStream, "xdel", key)
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execXSetID sets the last id of stream, XSETID key last-id
+// it is also used by aof rewriting to restore the last id of a stream whose last entries were deleted
+func execXSetID(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	id, errReply := parseStreamID(args[1], 0)
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	if lastEntryID, ok := s.LastEntryID(); ok && id.Less(lastEntryID) {
+		return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(id)
+	db.addAof(utils.ToCmdLine2("xsetid", args...))
+	db.notify(notifyStream, "xsetid", key)
+	return &reply.OkReply{}
+}
+
+// execXLen returns the number of entries in stream
+func execXLen(db *DB, args [][]byte) resp.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(s.Len()))
+}
+
+// rangeGeneric implements XRANGE key start end [COUNT count] and XREVRANGE key end start [COUNT count]
+func rangeGeneric(db *DB, args [][]byte, reverse bool) resp.Reply {
+	key := string(args[0])
+	rawStart, rawEnd := args[1], args[2]
+	if reverse {
+		rawStart, rawEnd = rawEnd, rawStart
+	}
+	start, startOk, errReply := parseRangeID(rawStart, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, endOk, errReply := parseRangeID(rawEnd, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return &reply.SyntaxErrReply{}
+		}
+		val, err := strconv.ParseInt(string(args[4]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(val)
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || !startOk || !endOk || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return entriesToReply(s.Range(start, end, count, reverse))
+}
+
+// execXRange returns entries in the range of ids
+func execXRange(db *DB, args [][]byte) resp.Reply {
+	return rangeGeneric(db, args, false)
+}
+
+// execXRevRange returns entries in the range of ids in reverse order
+func execXRevRange(db *DB, args [][]byte) resp.Reply {
+	return rangeGeneric(db, args, true)
+}
+
+// xreadArgs is the parsed arguments of XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+type xreadArgs struct {
+	count   int // 0 means no limit
+	block   bool
+	timeout time.Duration
+	keys    [][]byte
+	ids     [][]byte // `$` means the last id
+}
+
+func parseXReadArgs(args [][]byte) (*xreadArgs, reply.ErrorReply) {
+	xread := &xreadArgs{}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count > 0 {
+				xread.count = int(count)
+			}
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, reply.MakeErrReply("ERR timeout is negative")
+			}
+			if ms > math.MaxInt64/int64(time.Millisecond) {
+				return nil, reply.MakeErrReply("ERR timeout is out of range")
+			}
+			xread.block = true
+			xread.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, reply.MakeErrReply("ERR Unbalanced 'xread' list of streams: " +
+					"for each stream key an ID or '$' must be specified.")
+			}
+			xread.keys = rest[:len(rest)/2]
+			xread.ids = rest[len(rest)/2:]
+			return xread, nil
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+	}
+	return nil, &reply.SyntaxErrReply{}
+}
+
+// prepareXRead returns keys of XREAD, the executor replies the error if arguments are invalid
+func prepareXRead(args [][]byte) ([]string, []string) {
+	xread, errReply := parseXReadArgs(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return readAllKeys(xread.keys)
+}
+
+// parseBlockingXRead returns keys and timeout of XREAD, it blocks only with BLOCK option
+func parseBlockingXRead(args [][]byte) ([]string, time.Duration, bool, reply.ErrorReply) {
+	xread, errReply := parseXReadArgs(args)
+	if errReply != nil {
+		return nil, 0, false, errReply
+	}
+	_, keys := readAllKeys(xread.keys)
+	return keys, xread.timeout, xread.block, nil
+}
+
+// resolveXRead replaces `$` with the current last id, so a blocked XREAD gets entries added after it was called
+func resolveXRead(db *DB, args [][]byte) [][]byte {
+	// arguments have been checked by parseBlockingXRead
+	xread, _ := parseXReadArgs(args)
+	resolved := make([][]byte, len(args))
+	copy(resolved, args)
+	offset := len(args) - len(xread.ids)
+	for i, key := range xread.keys {
+		if string(xread.ids[i]) != "$" {
+			continue
+		}
+		lastID := stream.MinID
+		if s, _ := db.getAsStream(string(key)); s != nil {
+			lastID = s.LastID()
+		}
+		resolved[offset+i] = []byte(lastID.String())
+	}
+	return resolved
+}
+
+// execXRead returns entries with ids greater than the given ids from multiple streams.
+// it replies a null array if there is no such entry, and DB.Exec blocks the client if BLOCK option is given
+func execXRead(db *DB, args [][]byte) resp.Reply {
+	xread, errReply := parseXReadArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	ids := make([]stream.ID, len(xread.ids))
+	for i, arg := range xread.ids {
+		if string(arg) == "$" {
+			continue
+		}
+		id, errReply := parseStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	result := make([]resp.Reply, 0)
+	for i, key := range xread.keys {
+		s, errReply := db.getAsStream(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		if s == nil || string(xread.ids[i]) == "$" {
+			continue
+		}
+		start, ok := ids[i].Next()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, xread.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply(key),
+			entriesToReply(entries),
+		}))
+	}
+	if len(result) == 0 {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func init() {
+	RegisterCommand("XAdd", execXAdd, writeFirstKey, -5, acl.CatWrite, acl.CatStream)
+	RegisterCommand("XTrim", execXTrim, writeFirstKey, -4, acl.CatWrite, acl.CatStream)
+	RegisterCommand("XDel", execXDel, writeFirstKey, -3, acl.CatWrite, acl.CatStream)
+	RegisterCommand("XSetID", execXSetID, writeFirstKey, 3, acl.CatWrite, acl.CatStream)
+	RegisterCommand("XLen", execXLen, readFirstKey, 2, acl.CatRead, acl.CatStream)
+	RegisterCommand("XRange", execXRange, readFirstKey, -4, acl.CatRead, acl.CatStream)
+	RegisterCommand("XRevRange", execXRevRange, readFirstKey, -4, acl.CatRead, acl.CatStream)
+	RegisterCommand("XRead", execXRead, prepareXRead, -4, acl.CatRead, acl.CatStream, acl.CatBlocking)
+	blockingCommands["xread"] = &blockingCmd{
+		parse:    parseBlockingXRead,
+		resolve:  resolveXRead,
+		readOnly: true,
+	}
+}
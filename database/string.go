@@ -1,12 +1,15 @@
 package database
 
 import (
+	"go-redis/acl"
+	"go-redis/aof"
 	"go-redis/interface/database"
 	"go-redis/interface/resp"
 	"go-redis/lib/utils"
 	"go-redis/resp/reply"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //get
@@ -45,28 +48,86 @@ const (
 	updatePolicy        // set ex
 )
 
+// parseExpireOption parses the argument of EX/PX/EXAT/PXAT option into absolute expire time
+func parseExpireOption(option string, arg []byte, cmdName string) (time.Time, reply.ErrorReply) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if val <= 0 {
+		return time.Time{}, makeInvalidExpireErr(cmdName)
+	}
+	var expireTime time.Time
+	var ok bool
+	switch option {
+	case "EX":
+		expireTime, ok = toExpireTime(val, 1000, true)
+	case "PX":
+		expireTime, ok = toExpireTime(val, 1, true)
+	case "EXAT":
+		expireTime, ok = toExpireTime(val, 1000, false)
+	default: // PXAT
+		expireTime, ok = toExpireTime(val, 1, false)
+	}
+	if !ok {
+		return time.Time{}, makeInvalidExpireErr(cmdName)
+	}
+	return expireTime, nil
+}
+
 // execSet sets string value and time to live to the given key
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	value := args[1]
 	policy := upsertPolicy
+	var expireTime time.Time
+	hasTTL := false
+	keepTTL := false
+	returnOld := false
 	// parse options
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
-				if policy == updatePolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-			} else if arg == "XX" { // update policy
-				if policy == insertPolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = updatePolicy
-			} else {
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX": // insert
+			if policy == updatePolicy {
+				return &reply.SyntaxErrReply{}
+			}
+			policy = insertPolicy
+		case "XX": // update policy
+			if policy == insertPolicy {
+				return &reply.SyntaxErrReply{}
+			}
+			policy = updatePolicy
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || keepTTL || i+1 >= len(args) {
 				return &reply.SyntaxErrReply{}
 			}
+			var errReply reply.ErrorReply
+			expireTime, errReply = parseExpireOption(arg, args[i+1], "set")
+			if errReply != nil {
+				return errReply
+			}
+			hasTTL = true
+			i++ // skip ttl
+		case "KEEPTTL":
+			if hasTTL {
+				return &reply.SyntaxErrReply{}
+			}
+			keepTTL = true
+		case "GET":
+			returnOld = true
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	var old []byte
+	if returnOld {
+		var errReply reply.ErrorReply
+		old, errReply = db.getAsString(key)
+		if errReply != nil {
+			return errReply
 		}
 	}
 
@@ -77,6 +138,8 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 	var result int
 	switch policy {
 	case upsertPolicy:
+		// 先删除已过期的 key，否则 KEEPTTL 会保留已经过去的过期时间
+		db.IsExpired(key)
 		db.PutEntity(key, entity)
 		result = 1
 	case insertPolicy:
@@ -84,13 +147,132 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 	case updatePolicy:
 		result = db.PutIfExists(key, entity)
 	}
-	db.addAof(utils.ToCmdLine2("set", args...))
+	if result > 0 {
+		// aof 中只记录最终效果：set 本身 + 绝对时间的过期
+		if hasTTL {
+			db.Expire(key, expireTime)
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+			db.addAof(aof.MakeExpireCmd(key, expireTime))
+		} else if keepTTL {
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1], []byte("KEEPTTL")))
+		} else {
+			db.Persist(key) // 覆盖写会清除原来的过期时间
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+		}
+		db.notify(notifyString, "set", key)
+		if hasTTL {
+			db.notify(notifyGeneric, "expire", key)
+		}
+	}
+	if returnOld {
+		if old == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(old)
+	}
 	if result > 0 {
 		return &reply.OkReply{}
 	}
 	return &reply.NullBulkReply{}
 }
 
+// execSetEX sets string and its ttl in seconds
+func execSetEX(db *DB, args [][]byte) resp.Reply {
+	return setWithTTL(db, "EX", args, "setex")
+}
+
+// execPSetEX sets string and its ttl in milliseconds
+func execPSetEX(db *DB, args [][]byte) resp.Reply {
+	return setWithTTL(db, "PX", args, "psetex")
+}
+
+// setWithTTL implements SETEX and PSETEX, args are: key ttl value
+func setWithTTL(db *DB, option string, args [][]byte, cmdName string) resp.Reply {
+	key := string(args[0])
+	value := args[2]
+	expireTime, errReply := parseExpireOption(option, args[1], cmdName)
+	if errReply != nil {
+		return errReply
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: value,
+	})
+	db.Expire(key, expireTime)
+	db.addAof(utils.ToCmdLine2("set", args[0], args[2]))
+	db.addAof(aof.MakeExpireCmd(key, expireTime))
+	db.notify(notifyString, "set", key)
+	db.notify(notifyGeneric, "expire", key)
+	return &reply.OkReply{}
+}
+
+// execGetEX returns string value and optionally sets or removes its ttl
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func execGetEX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var expireTime time.Time
+	hasTTL := false
+	persist := false
+	for i := 1; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || persist || i+1 >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			var errReply reply.ErrorReply
+			expireTime, errReply = parseExpireOption(arg, args[i+1], "getex")
+			if errReply != nil {
+				return errReply
+			}
+			hasTTL = true
+			i++
+		case "PERSIST":
+			if hasTTL {
+				return &reply.SyntaxErrReply{}
+			}
+			persist = true
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		return &reply.NullBulkReply{}
+	}
+	if hasTTL {
+		db.Expire(key, expireTime)
+		db.addAof(aof.MakeExpireCmd(key, expireTime))
+		db.notify(notifyGeneric, "expire", key)
+	} else if persist {
+		if _, ok := db.TTL(key); ok {
+			db.Persist(key)
+			db.addAof(utils.ToCmdLine("persist", key))
+			db.notify(notifyGeneric, "persist", key)
+		}
+	}
+	return reply.MakeBulkReply(bytes)
+}
+
+// execGetDel returns string value and deletes the key
+func execGetDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.Remove(key)
+	db.addAof(utils.ToCmdLine("del", key))
+	db.notify(notifyGeneric, "del", key)
+	return reply.MakeBulkReply(bytes)
+}
+
 // execSetNX sets string if not exists
 func execSetNX(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
@@ -100,6 +282,9 @@ func execSetNX(db *DB, args [][]byte) resp.Reply {
 	}
 	result := db.PutIfAbsent(key, entity)
 	db.addAof(utils.ToCmdLine2("setnx", args...))
+	if result > 0 {
+		db.notify(notifyString, "set", key)
+	}
 	return reply.MakeIntReply(int64(result))
 }
 
@@ -120,8 +305,12 @@ func execMSet(db *DB, args [][]byte) resp.Reply {
 	for i, key := range keys {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.Persist(key)
 	}
 	db.addAof(utils.ToCmdLine2("mset", args...))
+	for _, key := range keys {
+		db.notify(notifyString, "set", key)
+	}
 	return &reply.OkReply{}
 }
 
@@ -176,6 +365,9 @@ func execMSetNX(db *DB, args [][]byte) resp.Reply {
 		db.PutEntity(key, &database.DataEntity{Data: value})
 	}
 	db.addAof(utils.ToCmdLine2("msetnx", args...))
+	for _, key := range keys {
+		db.notify(notifyString, "set", key)
+	}
 	return reply.MakeIntReply(1)
 }
 
@@ -189,10 +381,12 @@ func execGetSet(db *DB, args [][]byte) resp.Reply {
 		return err
 	}
 	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine2("getset", args...))
+	db.notify(notifyString, "set", key)
 	if old == nil {
 		return new(reply.NullBulkReply)
 	}
-	db.addAof(utils.ToCmdLine2("getset", args...))
 	return reply.MakeBulkReply(old)
 }
 
@@ -213,12 +407,14 @@ func execIncr(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val+1, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("incr", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val + 1)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: []byte("1"),
 	})
 	db.addAof(utils.ToCmdLine2("incr", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -245,12 +441,14 @@ func execIncrBy(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val+delta, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("incrby", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val + delta)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: args[1],
 	})
 	db.addAof(utils.ToCmdLine2("incrby", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(delta)
 }
 
@@ -271,6 +469,7 @@ func execDecr(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val-1, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("decr", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val - 1)
 	}
 	entity := &database.DataEntity{
@@ -278,6 +477,7 @@ func execDecr(db *DB, args [][]byte) resp.Reply {
 	}
 	db.PutEntity(key, entity)
 	db.addAof(utils.ToCmdLine2("decr", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(-1)
 }
 
@@ -303,6 +503,7 @@ func execDecrBy(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val-delta, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("decrby", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val - delta)
 	}
 	valueStr := strconv.FormatInt(-delta, 10)
@@ -310,6 +511,7 @@ func execDecrBy(db *DB, args [][]byte) resp.Reply {
 		Data: []byte(valueStr),
 	})
 	db.addAof(utils.ToCmdLine2("decrby", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(-delta)
 }
 
@@ -338,6 +540,7 @@ func execAppend(db *DB, args [][]byte) resp.Reply {
 		Data: bytes,
 	})
 	db.addAof(utils.ToCmdLine2("append", args...))
+	db.notify(notifyString, "append", key)
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -373,6 +576,7 @@ func execSetRange(db *DB, args [][]byte) resp.Reply {
 		Data: bytes,
 	})
 	db.addAof(utils.ToCmdLine2("setRange", args...))
+	db.notify(notifyString, "setrange", key)
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -421,19 +625,23 @@ func execGetRange(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("Set", execSet, -3)
-	RegisterCommand("SetNx", execSetNX, 3)
-	RegisterCommand("MSet", execMSet, -3)
-	RegisterCommand("MGet", execMGet, -2)
-	RegisterCommand("MSetNX", execMSetNX, -3)
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("Incr", execIncr, 2)
-	RegisterCommand("IncrBy", execIncrBy, 3)
-	RegisterCommand("Decr", execDecr, 2)
-	RegisterCommand("DecrBy", execDecrBy, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
-	RegisterCommand("Append", execAppend, 3)
-	RegisterCommand("SetRange", execSetRange, 4)
-	RegisterCommand("GetRange", execGetRange, 4)
+	RegisterCommand("Set", execSet, writeFirstKey, -3, acl.CatWrite, acl.CatString)
+	RegisterCommand("SetNx", execSetNX, writeFirstKey, 3, acl.CatWrite, acl.CatString)
+	RegisterCommand("SetEX", execSetEX, writeFirstKey, 4, acl.CatWrite, acl.CatString)
+	RegisterCommand("PSetEX", execPSetEX, writeFirstKey, 4, acl.CatWrite, acl.CatString)
+	RegisterCommand("MSet", execMSet, prepareMSet, -3, acl.CatWrite, acl.CatString)
+	RegisterCommand("MGet", execMGet, readAllKeys, -2, acl.CatRead, acl.CatString)
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, -3, acl.CatWrite, acl.CatString)
+	RegisterCommand("Get", execGet, readFirstKey, 2, acl.CatRead, acl.CatString)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, 3, acl.CatWrite, acl.CatString)
+	RegisterCommand("GetEX", execGetEX, writeFirstKey, -2, acl.CatWrite, acl.CatString)
+	RegisterCommand("GetDel", execGetDel, writeFirstKey, 2, acl.CatWrite, acl.CatString)
+	RegisterCommand("Incr", execIncr, writeFirstKey, 2, acl.CatWrite, acl.CatString)
+	RegisterCommand("IncrBy", execIncrBy, writeFirstKey, 3, acl.CatWrite, acl.CatString)
+	RegisterCommand("Decr", execDecr, writeFirstKey, 2, acl.CatWrite, acl.CatString)
+	RegisterCommand("DecrBy", execDecrBy, writeFirstKey, 3, acl.CatWrite, acl.CatString)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, 2, acl.CatRead, acl.CatString)
+	RegisterCommand("Append", execAppend, writeFirstKey, 3, acl.CatWrite, acl.CatString)
+	RegisterCommand("SetRange", execSetRange, writeFirstKey, 4, acl.CatWrite, acl.CatString)
+	RegisterCommand("GetRange", execGetRange, readFirstKey, 4, acl.CatRead, acl.CatString)
 }
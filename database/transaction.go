@@ -0,0 +1,294 @@
+package database
+
+import (
+	"errors"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+	"sync/atomic"
+)
+
+//MULTI EXEC DISCARD WATCH UNWATCH
+
+/* ---- Version Functions ---- */
+
+// watchedKey is the version of a key watched by at least one connection
+type watchedKey struct {
+	version uint32 // accessed atomically
+	refs    int    // number of watching connections, protected by DB.watchMu
+}
+
+// watch starts tracking version of the key, the caller should hold lock of the key
+func (db *DB) watch(key string) uint32 {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		raw = &watchedKey{}
+		db.versionMap.Put(key, raw)
+	}
+	w := raw.(*watchedKey)
+	w.refs++
+	return atomic.LoadUint32(&w.version)
+}
+
+// unwatch stops tracking version of the key if no connection is watching it
+func (db *DB) unwatch(key string) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return
+	}
+	w := raw.(*watchedKey)
+	w.refs--
+	if w.refs <= 0 {
+		db.versionMap.Remove(key)
+	}
+}
+
+// addVersion increases the version of given keys, transactions watching them will abort
+// keys not watched are skipped, so versions don't grow with the key space
+func (db *DB) addVersion(keys ...string) {
+	if db.versionMap.Len() == 0 {
+		return
+	}
+	for _, key := range keys {
+		if raw, ok := db.versionMap.Get(key); ok {
+			atomic.AddUint32(&raw.(*watchedKey).version, 1)
+		}
+	}
+}
+
+// touchAllWatched increases versions of all existing watched keys, it is used by FLUSHDB
+func (db *DB) touchAllWatched() {
+	db.versionMap.ForEach(func(key string, raw interface{}) bool {
+		if _, exists := db.data.Get(key); exists {
+			atomic.AddUint32(&raw.(*watchedKey).version, 1)
+		}
+		return true
+	})
+}
+
+// GetVersion returns version code for given key, it is always 0 if the key is not watched
+func (db *DB) GetVersion(key string) uint32 {
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint32(&raw.(*watchedKey).version)
+}
+
+// Watch records versions of the given keys, EXEC aborts if any of them is modified
+func Watch(db *DB, conn resp.Connection, args [][]byte) resp.Reply {
+	watching := conn.GetWatching()
+	keys := watching[db.index]
+	if keys == nil {
+		keys = make(map[string]uint32)
+		watching[db.index] = keys
+	}
+	_, readKeys := readAllKeys(args)
+	// 持有读锁，保证 WATCH 与并发的写命令有先后顺序
+	db.RWLocks(nil, readKeys)
+	defer db.RWUnLocks(nil, readKeys)
+	for _, key := range readKeys {
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		keys[key] = db.watch(key)
+	}
+	return reply.MakeOkReply()
+}
+
+// UnWatch forgets all watched keys
+func UnWatch(mdb *StandaloneDatabase, conn resp.Connection) resp.Reply {
+	mdb.unwatchAll(conn)
+	return reply.MakeOkReply()
+}
+
+// unwatchAll forgets watched keys of the connection in all db
+func (mdb *StandaloneDatabase) unwatchAll(conn resp.Connection) {
+	for dbIndex, keys := range conn.GetWatching() {
+		if dbIndex >= len(mdb.dbSet) {
+			continue
+		}
+		for key := range keys {
+			mdb.dbSet[dbIndex].unwatch(key)
+		}
+	}
+	conn.ClearWatching()
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, ver := range watching {
+		currentVersion := db.GetVersion(key)
+		if ver != currentVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// StartMulti starts multi-command-transaction
+func StartMulti(conn resp.Connection) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	conn.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// EnqueueCmd puts command line into `multi` pending queue
+func EnqueueCmd(conn resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	arity, ok := commandArity(cmdName)
+	if !ok {
+		err := reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		conn.AddTxError(errors.New(err.Error()))
+		return err
+	}
+	if !validateArity(arity, cmdLine) {
+		err := reply.MakeArgNumErrReply(cmdName)
+		conn.AddTxError(errors.New(err.Error()))
+		return err
+	}
+	conn.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// commandArity returns the arity of a db command or a server command, see serverCommands
+func commandArity(cmdName string) (int, bool) {
+	if cmd, ok := cmdTable[cmdName]; ok {
+		return cmd.arity, true
+	}
+	if cmd, ok := serverCommands[cmdName]; ok {
+		return cmd.arity, true
+	}
+	return 0, false
+}
+
+// DiscardMulti drops MULTI pending commands
+func DiscardMulti(mdb *StandaloneDatabase, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	conn.SetMultiState(false)
+	mdb.unwatchAll(conn)
+	return reply.MakeOkReply()
+}
+
+// execMulti executes queued commands of the transaction, the watched keys are always released
+func execMulti(mdb *StandaloneDatabase, db *DB, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		conn.SetMultiState(false)
+		mdb.unwatchAll(conn)
+	}()
+	if len(conn.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	watching := conn.GetWatching()
+	// keys watched in other db are checked before executing, only keys of the selected db are locked by ExecMulti
+	for dbIndex, keys := range watching {
+		if dbIndex != db.index && dbIndex < len(mdb.dbSet) && isWatchingChanged(mdb.dbSet[dbIndex], keys) {
+			return reply.MakeNullMultiBulkReply()
+		}
+	}
+	cmdLines := conn.GetQueuedCmdLine()
+	if hasSaveCommand(cmdLines) {
+		return mdb.execMultiWithSave(db, watching[db.index], cmdLines, conn)
+	}
+	return db.ExecMulti(watching[db.index], cmdLines, func(cmdLine CmdLine) resp.Reply {
+		return mdb.execServerCommand(conn, cmdLine)
+	})
+}
+
+func hasSaveCommand(cmdLines []CmdLine) bool {
+	for _, cmdLine := range cmdLines {
+		if strings.ToLower(string(cmdLine[0])) == "save" {
+			return true
+		}
+	}
+	return false
+}
+
+// execMultiWithSave executes a transaction containing SAVE, which copies all dbs at the point it is executed.
+// like a blocking SAVE of redis, locks of all dbs are held during the transaction, then SAVE copies keys directly
+func (mdb *StandaloneDatabase) execMultiWithSave(db *DB, watching map[string]uint32, cmdLines []CmdLine,
+	conn resp.Connection) resp.Reply {
+	writeKeys, _ := GetRelatedKeys(cmdLines)
+	defer db.wakeBlocked(writeKeys)
+	mdb.lockAllDBs()
+	defer mdb.unlockAllDBs()
+	return db.execMultiWithoutLock(watching, cmdLines, func(cmdLine CmdLine) resp.Reply {
+		if strings.ToLower(string(cmdLine[0])) == "save" {
+			return makeSaveReply(mdb.saveLocked())
+		}
+		return mdb.execServerCommand(conn, cmdLine)
+	})
+}
+
+// GetRelatedKeys analysis related keys of queued commands, server commands have no related keys
+func GetRelatedKeys(cmdLines []CmdLine) ([]string, []string) {
+	var writeKeys, readKeys []string
+	for _, cmdLine := range cmdLines {
+		cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+		if !ok {
+			continue
+		}
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	return writeKeys, readKeys
+}
+
+// hasWholeDBCommand returns whether any of cmdLines operates the whole db, see wholeDBCommands
+func hasWholeDBCommand(cmdLines []CmdLine) bool {
+	for _, cmdLine := range cmdLines {
+		if _, ok := wholeDBCommands[strings.ToLower(string(cmdLine[0]))]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecMulti executes multi commands atomically, it returns a null array if watched keys are changed
+// like redis, a command failed at runtime doesn't stop the others.
+// queued server commands like PUBLISH are executed by execServer in their order
+func (db *DB) ExecMulti(watching map[string]uint32, cmdLines []CmdLine, execServer func(CmdLine) resp.Reply) resp.Reply {
+	// prepare
+	writeKeys, readKeys := GetRelatedKeys(cmdLines)
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	defer db.wakeBlocked(writeKeys)
+	if hasWholeDBCommand(cmdLines) {
+		db.locker.LockAll()
+		defer db.locker.UnLockAll()
+	} else {
+		db.RWLocks(writeKeys, readKeys)
+		defer db.RWUnLocks(writeKeys, readKeys)
+	}
+	return db.execMultiWithoutLock(watching, cmdLines, execServer)
+}
+
+// execMultiWithoutLock executes multi commands, caller should hold locks of related keys and watched keys
+func (db *DB) execMultiWithoutLock(watching map[string]uint32, cmdLines []CmdLine,
+	execServer func(CmdLine) resp.Reply) resp.Reply {
+	if isWatchingChanged(db, watching) {
+		return reply.MakeNullMultiBulkReply()
+	}
+	results := make([]resp.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+		if !ok {
+			results = append(results, execServer(cmdLine))
+			continue
+		}
+		results = append(results, db.execWithoutLock(cmd, cmdLine))
+	}
+	return reply.MakeMultiRawReply(results)
+}
@@ -0,0 +1,337 @@
+package dict
+
+import (
+	"go-redis/lib/wildcard"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentDict is thread safe map using sharding lock
+// 分段加锁：key 按 hash 分到不同的 shard，每个 shard 有自己的读写锁
+type ConcurrentDict struct {
+	table []*shard
+	count int32
+}
+
+type shard struct {
+	m     map[string]interface{}
+	mutex sync.RWMutex
+}
+
+func computeCapacity(param int) (size int) {
+	if param <= 16 {
+		return 16
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	if n < 0 {
+		return math.MaxInt32
+	}
+	return n + 1
+}
+
+// MakeConcurrent creates ConcurrentDict with the given shard count, it will be rounded up to power of 2
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	shardCount = computeCapacity(shardCount)
+	table := make([]*shard, shardCount)
+	for i := 0; i < shardCount; i++ {
+		table[i] = &shard{
+			m: make(map[string]interface{}),
+		}
+	}
+	return &ConcurrentDict{
+		table: table,
+	}
+}
+
+const prime32 = uint32(16777619)
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (dict *ConcurrentDict) spread(hashCode uint32) uint32 {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	tableSize := uint32(len(dict.table))
+	return (tableSize - 1) & hashCode
+}
+
+func (dict *ConcurrentDict) getShard(index uint32) *shard {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	return dict.table[index]
+}
+
+// Get returns the binding value and whether the key is exist
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.getShard(dict.spread(fnv32(key)))
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, exists = s.m[key]
+	return
+}
+
+// Len returns the number of dict, O(1)
+func (dict *ConcurrentDict) Len() int {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	return int(atomic.LoadInt32(&dict.count))
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.getShard(dict.spread(fnv32(key)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0
+	}
+	dict.addCount()
+	s.m[key] = val
+	return 1
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.getShard(dict.spread(fnv32(key)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	dict.addCount()
+	return 1
+}
+
+// PutIfExists puts value if the key is exist and returns the number of inserted key-value
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.getShard(dict.spread(fnv32(key)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.getShard(dict.spread(fnv32(key)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		delete(s.m, key)
+		dict.decreaseCount()
+		return 1
+	}
+	return 0
+}
+
+func (dict *ConcurrentDict) addCount() int32 {
+	return atomic.AddInt32(&dict.count, 1)
+}
+
+func (dict *ConcurrentDict) decreaseCount() int32 {
+	return atomic.AddInt32(&dict.count, -1)
+}
+
+// ForEach traversal the dict shard by shard in ascending index order
+// it may not visit new entry inserted during traversal
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+
+	for _, s := range dict.table {
+		if !s.forEach(consumer) {
+			break
+		}
+	}
+}
+
+// forEach visits a snapshot of the shard, so that consumer can access the dict without dead lock
+func (s *shard) forEach(consumer Consumer) bool {
+	s.mutex.RLock()
+	keys := make([]string, 0, len(s.m))
+	values := make([]interface{}, 0, len(s.m))
+	for key, value := range s.m {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	s.mutex.RUnlock()
+	for i, key := range keys {
+		if !consumer(key, values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns all keys in dict
+func (dict *ConcurrentDict) Keys() []string {
+	keys := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// randomKey returns a key of the shard, ok is false if the shard is empty
+func (s *shard) randomKey() (key string, ok bool) {
+	if s == nil {
+		panic("shard is nil")
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// go 的 map 遍历起点本身就是随机的
+	for key := range s.m {
+		return key, true
+	}
+	return "", false
+}
+
+// maxRandomAttemptsFactor limits the attempts of picking random shards, in case of most shards are empty
+const maxRandomAttemptsFactor = 10
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (dict *ConcurrentDict) RandomKeys(limit int) []string {
+	size := dict.Len()
+	if size == 0 || limit <= 0 {
+		return nil
+	}
+	shardCount := len(dict.table)
+	result := make([]string, 0, limit)
+	for attempts := 0; len(result) < limit; attempts++ {
+		if attempts >= limit*maxRandomAttemptsFactor {
+			return dict.fillRandomKeys(result, limit, false)
+		}
+		s := dict.getShard(uint32(rand.Intn(shardCount)))
+		if key, ok := s.randomKey(); ok {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
+	size := dict.Len()
+	if limit >= size {
+		return dict.Keys()
+	}
+	if limit <= 0 {
+		return nil
+	}
+	shardCount := len(dict.table)
+	picked := make(map[string]struct{}, limit)
+	result := make([]string, 0, limit)
+	for attempts := 0; len(result) < limit; attempts++ {
+		if attempts >= limit*maxRandomAttemptsFactor {
+			return dict.fillRandomKeys(result, limit, true)
+		}
+		s := dict.getShard(uint32(rand.Intn(shardCount)))
+		if key, ok := s.randomKey(); ok {
+			if _, dup := picked[key]; dup {
+				continue
+			}
+			picked[key] = struct{}{}
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// fillRandomKeys fills result up to limit by traversing from a random shard,
+// it is used when random picking fails too many times, e.g. the dict is sparse
+func (dict *ConcurrentDict) fillRandomKeys(result []string, limit int, distinct bool) []string {
+	picked := make(map[string]struct{}, len(result))
+	for _, key := range result {
+		picked[key] = struct{}{}
+	}
+	var visited []string
+	shardCount := len(dict.table)
+	start := rand.Intn(shardCount)
+	for i := 0; i < shardCount && len(result) < limit; i++ {
+		s := dict.getShard(uint32((start + i) % shardCount))
+		s.forEach(func(key string, val interface{}) bool {
+			visited = append(visited, key)
+			if _, dup := picked[key]; dup && distinct {
+				return true
+			}
+			picked[key] = struct{}{}
+			result = append(result, key)
+			return len(result) < limit
+		})
+	}
+	// duplicated keys are allowed, repeat the visited keys
+	for !distinct && len(visited) > 0 && len(result) < limit {
+		result = append(result, visited[rand.Intn(len(visited))])
+	}
+	return result
+}
+
+// DictScan uses shard index as cursor, it visits whole shards until about count keys are visited.
+// A shard is never split between two calls, so keys existing during the whole traversal are always returned,
+// no matter how many keys are inserted or removed concurrently.
+func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result := make([][]byte, 0)
+	var matcher *wildcard.Pattern
+	if pattern != "*" {
+		matcher = wildcard.CompilePattern(pattern)
+	}
+	shardCount := len(dict.table)
+	if cursor < 0 || cursor >= shardCount {
+		return result, 0
+	}
+	visited := 0
+	shardIndex := cursor
+	for ; shardIndex < shardCount && visited < count; shardIndex++ {
+		s := dict.table[shardIndex]
+		s.mutex.RLock()
+		for key := range s.m {
+			if matcher == nil || matcher.IsMatch(key) {
+				result = append(result, []byte(key))
+			}
+		}
+		visited += len(s.m)
+		s.mutex.RUnlock()
+	}
+	if shardIndex >= shardCount {
+		return result, 0
+	}
+	return result, shardIndex
+}
+
+// Clear removes all keys in dict
+func (dict *ConcurrentDict) Clear() {
+	for _, s := range dict.table {
+		s.mutex.Lock()
+		atomic.AddInt32(&dict.count, -int32(len(s.m)))
+		s.m = make(map[string]interface{})
+		s.mutex.Unlock()
+	}
+}
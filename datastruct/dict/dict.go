@@ -1,5 +1,7 @@
 package dict
 
+import "go-redis/lib/wildcard"
+
 // Consumer redis 字典
 // Consumer is used to traversal dict, if it returns false the traversal will be break
 type Consumer func(key string, val interface{}) bool
@@ -17,4 +19,24 @@ type Dict interface {
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
 	Clear()
+	// DictScan visits about count keys start from cursor, returns keys matching pattern and the next cursor,
+	// the next cursor is 0 when the traversal is finished
+	DictScan(cursor int, count int, pattern string) ([][]byte, int)
+}
+
+// scanAll returns all keys matching pattern in one call,
+// it is only used by small compact dicts and SyncDict which has no stable bucket layout, the returned cursor is always 0
+func scanAll(dict Dict, pattern string) ([][]byte, int) {
+	result := make([][]byte, 0, dict.Len())
+	var matcher *wildcard.Pattern
+	if pattern != "*" {
+		matcher = wildcard.CompilePattern(pattern)
+	}
+	dict.ForEach(func(key string, val interface{}) bool {
+		if matcher == nil || matcher.IsMatch(key) {
+			result = append(result, []byte(key))
+		}
+		return true
+	})
+	return result, 0
 }
@@ -0,0 +1,137 @@
+package dict
+
+import "math/rand"
+
+// ListDict stores key-value pairs in a slice, it is not thread safe
+// 和 redis 的 listpack 编码类似：元素少的时候线性查找比哈希表更省内存，也足够快
+type ListDict struct {
+	entries []*entry
+}
+
+type entry struct {
+	key string
+	val interface{}
+}
+
+// MakeListDict makes a new ListDict
+func MakeListDict() *ListDict {
+	return &ListDict{
+		entries: make([]*entry, 0),
+	}
+}
+
+func (dict *ListDict) indexOf(key string) int {
+	for i, e := range dict.entries {
+		if e.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the binding value and whether the key is exist
+func (dict *ListDict) Get(key string) (val interface{}, exists bool) {
+	i := dict.indexOf(key)
+	if i < 0 {
+		return nil, false
+	}
+	return dict.entries[i].val, true
+}
+
+// Len returns the number of dict
+func (dict *ListDict) Len() int {
+	return len(dict.entries)
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (dict *ListDict) Put(key string, val interface{}) (result int) {
+	i := dict.indexOf(key)
+	if i >= 0 {
+		dict.entries[i].val = val
+		return 0
+	}
+	dict.entries = append(dict.entries, &entry{key: key, val: val})
+	return 1
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (dict *ListDict) PutIfAbsent(key string, val interface{}) (result int) {
+	if dict.indexOf(key) >= 0 {
+		return 0
+	}
+	dict.entries = append(dict.entries, &entry{key: key, val: val})
+	return 1
+}
+
+// PutIfExists puts value if the key is exist and returns the number of inserted key-value
+func (dict *ListDict) PutIfExists(key string, val interface{}) (result int) {
+	i := dict.indexOf(key)
+	if i < 0 {
+		return 0
+	}
+	dict.entries[i].val = val
+	return 1
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (dict *ListDict) Remove(key string) (result int) {
+	i := dict.indexOf(key)
+	if i < 0 {
+		return 0
+	}
+	dict.entries = append(dict.entries[:i], dict.entries[i+1:]...)
+	return 1
+}
+
+// ForEach traversal the dict
+func (dict *ListDict) ForEach(consumer Consumer) {
+	for _, e := range dict.entries {
+		if !consumer(e.key, e.val) {
+			break
+		}
+	}
+}
+
+// Keys returns all keys in dict
+func (dict *ListDict) Keys() []string {
+	result := make([]string, len(dict.entries))
+	for i, e := range dict.entries {
+		result[i] = e.key
+	}
+	return result
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (dict *ListDict) RandomKeys(limit int) []string {
+	if len(dict.entries) == 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
+	for i := 0; i < limit; i++ {
+		result[i] = dict.entries[rand.Intn(len(dict.entries))].key
+	}
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (dict *ListDict) RandomDistinctKeys(limit int) []string {
+	size := limit
+	if size > len(dict.entries) {
+		size = len(dict.entries)
+	}
+	result := make([]string, size)
+	for i, j := range rand.Perm(len(dict.entries))[:size] {
+		result[i] = dict.entries[j].key
+	}
+	return result
+}
+
+// Clear removes all keys in dict
+func (dict *ListDict) Clear() {
+	*dict = *MakeListDict()
+}
+
+// DictScan returns all keys matching pattern, the cursor is always 0
+func (dict *ListDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	return scanAll(dict, pattern)
+}
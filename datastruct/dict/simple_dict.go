@@ -1,56 +1,126 @@
 package dict
 
-// SimpleDict wraps a map, it is not thread safe
+import (
+	"go-redis/lib/wildcard"
+	"math/bits"
+	"math/rand"
+)
+
+// minBuckets is the initial number of buckets of SimpleDict
+const minBuckets = 4
+
+// SimpleDict is a hash table with chained buckets, it is not thread safe.
+// the number of buckets is always a power of 2, so DictScan can use the reverse binary cursor like redis:
+// keys existing during the whole traversal are returned even if the table grows or shrinks between calls
 type SimpleDict struct {
-	m map[string]interface{}
+	buckets []*simpleEntry
+	size    int
+	// iterating is the number of running ForEach, the table is not resized during iterating
+	iterating int
+}
+
+type simpleEntry struct {
+	key  string
+	val  interface{}
+	next *simpleEntry
 }
 
-// MakeSimple makes a new map
+// MakeSimple makes a new hash table
 func MakeSimple() *SimpleDict {
 	return &SimpleDict{
-		m: make(map[string]interface{}),
+		buckets: make([]*simpleEntry, minBuckets),
+	}
+}
+
+func (dict *SimpleDict) bucketIndex(key string) int {
+	return int(fnv32(key) & uint32(len(dict.buckets)-1))
+}
+
+func (dict *SimpleDict) find(key string) *simpleEntry {
+	for e := dict.buckets[dict.bucketIndex(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// resize doubles the table if it is full, or halves it if it is less than 1/8 used.
+// 与 redis 不同，这里一次性完成 rehash，扩容的代价均摊到每次插入
+func (dict *SimpleDict) resize() {
+	if dict.iterating > 0 {
+		return
+	}
+	n := len(dict.buckets)
+	switch {
+	case dict.size > n:
+		n *= 2
+	case n > minBuckets && dict.size < n/8:
+		n /= 2
+	default:
+		return
 	}
+	buckets := make([]*simpleEntry, n)
+	for _, e := range dict.buckets {
+		for e != nil {
+			next := e.next
+			i := int(fnv32(e.key) & uint32(n-1))
+			e.next = buckets[i]
+			buckets[i] = e
+			e = next
+		}
+	}
+	dict.buckets = buckets
 }
 
 // Get returns the binding value and whether the key is exist
 func (dict *SimpleDict) Get(key string) (val interface{}, exists bool) {
-	val, ok := dict.m[key]
-	return val, ok
+	e := dict.find(key)
+	if e == nil {
+		return nil, false
+	}
+	return e.val, true
 }
 
 // Len returns the number of dict
 func (dict *SimpleDict) Len() int {
-	if dict.m == nil {
-		panic("m is nil")
-	}
-	return len(dict.m)
+	return dict.size
 }
 
 // Put puts key value into dict and returns the number of new inserted key-value
 func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	dict.m[key] = val
-	if existed {
+	if e := dict.find(key); e != nil {
+		e.val = val
 		return 0
 	}
+	dict.insert(key, val)
 	return 1
 }
 
+func (dict *SimpleDict) insert(key string, val interface{}) {
+	i := dict.bucketIndex(key)
+	dict.buckets[i] = &simpleEntry{
+		key:  key,
+		val:  val,
+		next: dict.buckets[i],
+	}
+	dict.size++
+	dict.resize()
+}
+
 // PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
 func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	if existed {
+	if dict.find(key) != nil {
 		return 0
 	}
-	dict.m[key] = val
+	dict.insert(key, val)
 	return 1
 }
 
 // PutIfExists puts value if the key is exist and returns the number of inserted key-value
 func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	if existed {
-		dict.m[key] = val
+	if e := dict.find(key); e != nil {
+		e.val = val
 		return 1
 	}
 	return 0
@@ -58,59 +128,102 @@ func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
 
 // Remove removes the key and return the number of deleted key-value
 func (dict *SimpleDict) Remove(key string) (result int) {
-	_, existed := dict.m[key]
-	delete(dict.m, key)
-	if existed {
-		return 1
+	i := dict.bucketIndex(key)
+	for p := &dict.buckets[i]; *p != nil; p = &(*p).next {
+		if (*p).key == key {
+			*p = (*p).next
+			dict.size--
+			dict.resize()
+			return 1
+		}
 	}
 	return 0
 }
 
 // Keys returns all keys in dict
 func (dict *SimpleDict) Keys() []string {
-	result := make([]string, len(dict.m))
-	i := 0
-	for k := range dict.m {
-		result[i] = k
+	result := make([]string, 0, dict.size)
+	for _, e := range dict.buckets {
+		for ; e != nil; e = e.next {
+			result = append(result, e.key)
+		}
 	}
 	return result
 }
 
-// ForEach traversal the dict
+// ForEach traversal the dict, the consumer may remove keys during traversal
 func (dict *SimpleDict) ForEach(consumer Consumer) {
-	for k, v := range dict.m {
-		if !consumer(k, v) {
-			break
+	dict.iterating++
+	defer func() {
+		dict.iterating--
+		dict.resize()
+	}()
+	for _, e := range dict.buckets {
+		for e != nil {
+			next := e.next
+			if !consumer(e.key, e.val) {
+				return
+			}
+			e = next
 		}
 	}
 }
 
+// randomEntry picks a non-empty bucket randomly, then a random entry in it
+func (dict *SimpleDict) randomEntry() *simpleEntry {
+	var head *simpleEntry
+	for head == nil {
+		head = dict.buckets[rand.Intn(len(dict.buckets))]
+	}
+	n := 0
+	for e := head; e != nil; e = e.next {
+		n++
+	}
+	e := head
+	for i := rand.Intn(n); i > 0; i-- {
+		e = e.next
+	}
+	return e
+}
+
 // RandomKeys randomly returns keys of the given number, may contain duplicated key
 func (dict *SimpleDict) RandomKeys(limit int) []string {
+	if dict.size == 0 || limit <= 0 {
+		return []string{}
+	}
 	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		for k := range dict.m {
-			result[i] = k
-			break
-		}
+	for i := range result {
+		result[i] = dict.randomEntry().key
 	}
 	return result
 }
 
 // RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
 func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
-	size := limit
-	if size > len(dict.m) {
-		size = len(dict.m)
-	}
-	result := make([]string, size)
-	i := 0
-	for k := range dict.m {
-		if i == limit {
-			break
+	if limit <= 0 {
+		return []string{}
+	}
+	// limit comes from client, clamp it before any arithmetic or allocation
+	if limit > dict.size {
+		limit = dict.size
+	}
+	if limit*2 > dict.size {
+		// 需要的数量接近总数时，打乱全部 key 比反复随机挑选更快
+		keys := dict.Keys()
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		return keys[:limit]
+	}
+	picked := make(map[string]struct{}, limit)
+	result := make([]string, 0, limit)
+	for len(result) < limit {
+		key := dict.randomEntry().key
+		if _, ok := picked[key]; ok {
+			continue
 		}
-		result[i] = k
-		i++
+		picked[key] = struct{}{}
+		result = append(result, key)
 	}
 	return result
 }
@@ -119,3 +232,32 @@ func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
 func (dict *SimpleDict) Clear() {
 	*dict = *MakeSimple()
 }
+
+// DictScan visits buckets from cursor until about count keys are visited, the cursor is a bucket index
+// with reversed bits, which is increased from the highest bit, so buckets split or merged by resizing
+// are never visited twice or skipped
+func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result := make([][]byte, 0)
+	var matcher *wildcard.Pattern
+	if pattern != "*" {
+		matcher = wildcard.CompilePattern(pattern)
+	}
+	mask := uint64(len(dict.buckets) - 1)
+	v := uint64(cursor)
+	visited := 0
+	for {
+		for e := dict.buckets[v&mask]; e != nil; e = e.next {
+			if matcher == nil || matcher.IsMatch(e.key) {
+				result = append(result, []byte(e.key))
+			}
+			visited++
+		}
+		// 将掩码以外的位置 1 后按位反转再加一，即从高位开始递增游标
+		v |= ^mask
+		v = bits.Reverse64(bits.Reverse64(v) + 1)
+		if v == 0 || visited >= count {
+			break
+		}
+	}
+	return result, int(v)
+}
@@ -114,10 +114,15 @@ func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
 		}
 		return true
 	})
-	return result
+	return result[:i]
 }
 
 // Clear removes all keys in dict
 func (dict *SyncDict) Clear() {
 	*dict = *MakeSyncDict() //直接来新的，旧的让gc自己收
 }
+
+// DictScan returns all keys matching pattern, the cursor is always 0
+func (dict *SyncDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	return scanAll(dict, pattern)
+}
@@ -0,0 +1,197 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+ * ScoreBorder is a struct represents `min` `max` parameter of redis command `ZRANGEBYSCORE`
+ * can accept:
+ *   int or float value, such as 2.718, 2, -2.718, -2 ...
+ *   exclusive int or float value, such as (2.718, (2, (-2.718, (-2 ...
+ *   infinity: +inf, -inf， inf(same as +inf)
+ */
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border represents range of a sorted set, min border and max border are both Border
+type Border interface {
+	// greater returns whether the element is not above the border, used for max border
+	greater(element *Element) bool
+	// less returns whether the element is not below the border, used for min border
+	less(element *Element) bool
+	getValue() interface{}
+	getExclude() bool
+	// isEmptyRange returns true if there is nothing between this min border and the given max border
+	isEmptyRange(max Border) bool
+}
+
+// ScoreBorder represents range of score, e.g. (1 or 1.5 or +inf
+// infinity is kept in Value as math.Inf, since members may have infinite scores too
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+// if max.greater(score) then the score is within the upper border
+// do not use min.greater()
+func (border *ScoreBorder) greater(element *Element) bool {
+	value := element.Score
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	value := element.Score
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+func (border *ScoreBorder) getValue() interface{} {
+	return border.Value
+}
+
+func (border *ScoreBorder) getExclude() bool {
+	return border.Exclude
+}
+
+func (border *ScoreBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+var scorePositiveInfBorder = &ScoreBorder{
+	Value: math.Inf(1),
+}
+
+var scoreNegativeInfBorder = &ScoreBorder{
+	Value: math.Inf(-1),
+}
+
+// ParseScoreBorder creates ScoreBorder from redis arguments
+func ParseScoreBorder(s string) (Border, error) {
+	if s == "inf" || s == "+inf" {
+		return scorePositiveInfBorder, nil
+	}
+	if s == "-inf" {
+		return scoreNegativeInfBorder, nil
+	}
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value != value { // NaN
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	return &ScoreBorder{
+		Value:   value,
+		Exclude: exclude,
+	}, nil
+}
+
+// LexBorder represents range of member, e.g. [a or (a or + or -
+// lex range only makes sense when all members have the same score
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+func (border *LexBorder) getValue() interface{} {
+	return border.Value
+}
+
+func (border *LexBorder) getExclude() bool {
+	return border.Exclude
+}
+
+func (border *LexBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+var lexPositiveInfBorder = &LexBorder{
+	Inf: positiveInf,
+}
+
+var lexNegativeInfBorder = &LexBorder{
+	Inf: negativeInf,
+}
+
+// ParseLexBorder creates LexBorder from redis arguments
+func ParseLexBorder(s string) (Border, error) {
+	if s == "+" {
+		return lexPositiveInfBorder, nil
+	}
+	if s == "-" {
+		return lexNegativeInfBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: true,
+		}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: false,
+		}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
+
+func strconv64(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
@@ -0,0 +1,261 @@
+package sortedset
+
+import "go-redis/datastruct/dict"
+
+// SortedSet is a set which keys sorted by bound score
+// 跳表负责按分数排序，dict 负责 O(1) 地通过 member 查 score
+type SortedSet struct {
+	dict     *dict.SimpleDict
+	skiplist *skiplist
+}
+
+// Make makes a new SortedSet
+func Make() *SortedSet {
+	return &SortedSet{
+		dict:     dict.MakeSimple(),
+		skiplist: makeSkiplist(),
+	}
+}
+
+// Add puts member into set,  and returns whether has inserted new node
+func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	element, ok := sortedSet.Get(member)
+	sortedSet.dict.Put(member, &Element{
+		Member: member,
+		Score:  score,
+	})
+	if ok {
+		if score != element.Score {
+			sortedSet.skiplist.remove(member, element.Score)
+			sortedSet.skiplist.insert(member, score)
+		}
+		return false
+	}
+	sortedSet.skiplist.insert(member, score)
+	return true
+}
+
+// Len returns number of members in set
+func (sortedSet *SortedSet) Len() int64 {
+	return int64(sortedSet.dict.Len())
+}
+
+// Get returns the given member
+func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	raw, ok := sortedSet.dict.Get(member)
+	if !ok {
+		return nil, false
+	}
+	return raw.(*Element), true
+}
+
+// Remove removes the given member from set
+func (sortedSet *SortedSet) Remove(member string) bool {
+	v, ok := sortedSet.Get(member)
+	if ok {
+		sortedSet.skiplist.remove(member, v.Score)
+		sortedSet.dict.Remove(member)
+		return true
+	}
+	return false
+}
+
+// GetRank returns the rank of the given member, sort by ascending order, rank starts from 0
+func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	element, ok := sortedSet.Get(member)
+	if !ok {
+		return -1
+	}
+	r := sortedSet.skiplist.getRank(member, element.Score)
+	if desc {
+		r = sortedSet.skiplist.length - r
+	} else {
+		r--
+	}
+	return r
+}
+
+// ForEachByRank visits each member which rank within [start, stop), sort by ascending order, rank starts from 0
+func (sortedSet *SortedSet) ForEachByRank(start int64, stop int64, desc bool, consumer func(element *Element) bool) {
+	size := sortedSet.Len()
+	if start < 0 || start >= size {
+		panic("illegal start " + strconv64(start))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv64(stop))
+	}
+
+	// find start node
+	var node *node
+	if desc {
+		node = sortedSet.skiplist.tail
+		if start > 0 {
+			node = sortedSet.skiplist.getByRank(size - start)
+		}
+	} else {
+		node = sortedSet.skiplist.header.level[0].forward
+		if start > 0 {
+			node = sortedSet.skiplist.getByRank(start + 1)
+		}
+	}
+
+	sliceSize := int(stop - start)
+	for i := 0; i < sliceSize; i++ {
+		if !consumer(&node.Element) {
+			break
+		}
+		if desc {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+}
+
+// RangeByRank returns members which rank within [start, stop), sort by ascending order, rank starts from 0
+func (sortedSet *SortedSet) RangeByRank(start int64, stop int64, desc bool) []*Element {
+	sliceSize := int(stop - start)
+	slice := make([]*Element, sliceSize)
+	i := 0
+	sortedSet.ForEachByRank(start, stop, desc, func(element *Element) bool {
+		slice[i] = element
+		i++
+		return true
+	})
+	return slice
+}
+
+// RangeCount returns the number of members which score or member within the given border,
+// it is the distance between ranks of the first and the last member in range, which costs O(log N)
+func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
+	first := sortedSet.skiplist.getFirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := sortedSet.skiplist.getLastInRange(min, max)
+	return sortedSet.skiplist.getRank(last.Member, last.Score) -
+		sortedSet.skiplist.getRank(first.Member, first.Score) + 1
+}
+
+// ForEach visits members which score or member within the given border
+func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	// find start node
+	var node *node
+	if desc {
+		node = sortedSet.skiplist.getLastInRange(min, max)
+	} else {
+		node = sortedSet.skiplist.getFirstInRange(min, max)
+	}
+
+	for node != nil && offset > 0 {
+		if desc {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+		offset--
+	}
+	if node != nil && (!min.less(&node.Element) || !max.greater(&node.Element)) {
+		return // offset skipped the whole range
+	}
+
+	// A negative limit returns all elements from the offset
+	for i := 0; (i < int(limit) || limit < 0) && node != nil; i++ {
+		if !consumer(&node.Element) {
+			break
+		}
+		if desc {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+		if node == nil {
+			break
+		}
+		gtMin := min.less(&node.Element)
+		ltMax := max.greater(&node.Element)
+		if !gtMin || !ltMax {
+			break // break through score border
+		}
+	}
+}
+
+// Range returns members which score or member within the given border
+// param limit: <0 means no limit
+func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	slice := make([]*Element, 0)
+	sortedSet.ForEach(min, max, offset, limit, desc, func(element *Element) bool {
+		slice = append(slice, element)
+		return true
+	})
+	return slice
+}
+
+// RemoveRange removes members which score or member within the given border
+func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.RemoveRange(min, max, 0)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// PopMin removes and returns the members with the lowest scores
+func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	if count <= 0 {
+		return nil
+	}
+	first := sortedSet.skiplist.getFirstInRange(scoreNegativeInfBorder, scorePositiveInfBorder)
+	if first == nil {
+		return nil
+	}
+	border := &ScoreBorder{
+		Value:   first.Score,
+		Exclude: false,
+	}
+	removed := sortedSet.skiplist.RemoveRange(border, scorePositiveInfBorder, count)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMax removes and returns the members with the highest scores
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	size := sortedSet.Len()
+	if int64(count) > size {
+		count = int(size)
+	}
+	if count <= 0 {
+		return nil
+	}
+	removed := sortedSet.RangeByRank(0, int64(count), true)
+	for _, element := range removed {
+		sortedSet.Remove(element.Member)
+	}
+	return removed
+}
+
+// RemoveByRank removes member ranking within [start, stop)
+// sort by ascending order and rank starts from 0
+func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := sortedSet.skiplist.RemoveRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// Scan returns members matching pattern and the next cursor, see dict.Dict.DictScan
+func (sortedSet *SortedSet) Scan(cursor int, count int, pattern string) ([]*Element, int) {
+	members, next := sortedSet.dict.DictScan(cursor, count, pattern)
+	result := make([]*Element, 0, len(members))
+	for _, member := range members {
+		element, _ := sortedSet.Get(string(member))
+		result = append(result, element)
+	}
+	return result, next
+}
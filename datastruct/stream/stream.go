@@ -0,0 +1,320 @@
+// Package stream implements an append-only log of entries ordered by ID, like redis stream
+package stream
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pageSize is the max number of entries in a page
+// 和 redis 用 listpack 存储一段连续的 entry 类似：entry 按 ID 有序地存放在页中，追加和从头部裁剪只涉及首尾的页
+const pageSize = 128
+
+var (
+	// ErrInvalidID is returned when parsing a malformed id
+	ErrInvalidID = errors.New("invalid stream id")
+	// ErrIDTooSmall is returned when adding an entry whose id is not greater than the last id
+	ErrIDTooSmall = errors.New("stream id is equal or smaller than the last id")
+	// ErrIDExhausted is returned when no id greater than the last id is left
+	ErrIDExhausted = errors.New("stream has exhausted the last possible id")
+)
+
+// ID identifies an entry, it is formatted as `ms-seq`: unix time in milliseconds and sequence number in the millisecond
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID is the smallest id, which is `-` in range commands
+	MinID = ID{}
+	// MaxID is the greatest id, which is `+` in range commands
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+// ParseID parses `ms-seq`, defaultSeq is used if the id is only `ms`
+func ParseID(s string, defaultSeq uint64) (ID, error) {
+	msPart, seqPart := s, ""
+	hasSeq := false
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		msPart, seqPart = s[:i], s[i+1:]
+		hasSeq = true
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// String formats id as `ms-seq`
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less tells whether id is smaller than other
+func (id ID) Less(other ID) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// Next returns the smallest id greater than id, it returns false if id is MaxID
+func (id ID) Next() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest id smaller than id, it returns false if id is MinID
+func (id ID) Prev() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// Entry is an item of stream, Fields are field value pairs: field1, value1, field2, value2 ...
+// an entry is never modified after added, so it can be shared by replies
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// Stream is a log of entries in ascending order of id
+type Stream struct {
+	pages [][]*Entry // every page is not empty
+	size  int
+	// lastID is the greatest id ever added, new ids must be greater than it even if the entry was deleted
+	lastID ID
+}
+
+// Make creates an empty stream
+func Make() *Stream {
+	return &Stream{}
+}
+
+// Len returns the number of entries
+func (s *Stream) Len() int {
+	return s.size
+}
+
+// LastID returns the greatest id ever added, or set by SetLastID
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID changes the last id, caller should make sure it is not smaller than ids of entries
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+// LastEntryID returns id of the last entry, it returns false if stream is empty
+func (s *Stream) LastEntryID() (ID, bool) {
+	if s.size == 0 {
+		return ID{}, false
+	}
+	page := s.pages[len(s.pages)-1]
+	return page[len(page)-1].ID, true
+}
+
+// NextID generates id for an entry added at ms, the sequence number increases if ms is not greater than the last id
+func (s *Stream) NextID(ms uint64) (ID, error) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, nil
+	}
+	// the clock goes backwards or too many entries in the millisecond
+	next, ok := s.lastID.Next()
+	if !ok {
+		return ID{}, ErrIDExhausted
+	}
+	return next, nil
+}
+
+// NextSeqID generates id in the given ms, which is `ms-*` in XADD
+func (s *Stream) NextSeqID(ms uint64) (ID, error) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, nil
+	}
+	if ms < s.lastID.Ms {
+		return ID{}, ErrIDTooSmall
+	}
+	if s.lastID.Seq == math.MaxUint64 {
+		if ms == math.MaxUint64 {
+			return ID{}, ErrIDExhausted
+		}
+		return ID{}, ErrIDTooSmall
+	}
+	return ID{Ms: ms, Seq: s.lastID.Seq + 1}, nil
+}
+
+// Add appends an entry, id must be greater than the last id
+func (s *Stream) Add(id ID, fields [][]byte) error {
+	if !s.lastID.Less(id) {
+		return ErrIDTooSmall
+	}
+	entry := &Entry{ID: id, Fields: fields}
+	if len(s.pages) == 0 || len(s.pages[len(s.pages)-1]) >= pageSize {
+		page := make([]*Entry, 0, pageSize)
+		s.pages = append(s.pages, page)
+	}
+	last := len(s.pages) - 1
+	s.pages[last] = append(s.pages[last], entry)
+	s.size++
+	s.lastID = id
+	return nil
+}
+
+// search returns the position of the first entry whose id is not less than the given id
+// page equals len(s.pages) if there is no such entry
+func (s *Stream) search(id ID) (page int, offset int) {
+	page = sort.Search(len(s.pages), func(i int) bool {
+		p := s.pages[i]
+		return !p[len(p)-1].ID.Less(id)
+	})
+	if page == len(s.pages) {
+		return page, 0
+	}
+	p := s.pages[page]
+	offset = sort.Search(len(p), func(i int) bool {
+		return !p[i].ID.Less(id)
+	})
+	return page, offset
+}
+
+// Delete removes the entry of the given id, it returns false if there is no such entry
+func (s *Stream) Delete(id ID) bool {
+	page, offset := s.search(id)
+	if page == len(s.pages) || s.pages[page][offset].ID != id {
+		return false
+	}
+	p := s.pages[page]
+	copy(p[offset:], p[offset+1:])
+	p[len(p)-1] = nil
+	p = p[:len(p)-1]
+	if len(p) == 0 {
+		s.pages = append(s.pages[:page], s.pages[page+1:]...)
+	} else {
+		s.pages[page] = p
+	}
+	s.size--
+	return true
+}
+
+// Range returns entries whose id is between start and end, both inclusive.
+// entries are in descending order if reverse is true, count <= 0 means no limit
+func (s *Stream) Range(start ID, end ID, count int, reverse bool) []*Entry {
+	result := make([]*Entry, 0)
+	if end.Less(start) {
+		return result
+	}
+	if !reverse {
+		page, offset := s.search(start)
+		for ; page < len(s.pages); page++ {
+			for _, entry := range s.pages[page][offset:] {
+				if end.Less(entry.ID) || (count > 0 && len(result) >= count) {
+					return result
+				}
+				result = append(result, entry)
+			}
+			offset = 0
+		}
+		return result
+	}
+	// the last entry not greater than end is just before the first entry greater than end
+	page, offset := len(s.pages), 0
+	if next, ok := end.Next(); ok {
+		page, offset = s.search(next)
+	}
+	for {
+		if offset == 0 {
+			if page == 0 {
+				return result
+			}
+			page--
+			offset = len(s.pages[page])
+		}
+		offset--
+		entry := s.pages[page][offset]
+		if entry.ID.Less(start) || (count > 0 && len(result) >= count) {
+			return result
+		}
+		result = append(result, entry)
+	}
+}
+
+// removeFirst removes the first n entries
+func (s *Stream) removeFirst(n int) {
+	s.size -= n
+	for n > 0 {
+		p := s.pages[0]
+		if n >= len(p) {
+			s.pages[0] = nil
+			s.pages = s.pages[1:]
+			n -= len(p)
+			continue
+		}
+		// copy the rest, so removed entries can be collected
+		rest := make([]*Entry, len(p)-n, pageSize)
+		copy(rest, p[n:])
+		s.pages[0] = rest
+		n = 0
+	}
+}
+
+// TrimMaxLen removes the oldest entries until there are at most maxLen entries, but no more than limit entries.
+// limit <= 0 means no limit, it returns the number of removed entries
+func (s *Stream) TrimMaxLen(maxLen int, limit int) int {
+	if s.size <= maxLen {
+		return 0
+	}
+	return s.trim(s.size-maxLen, limit)
+}
+
+// TrimMinID removes entries whose id is less than minID, but no more than limit entries.
+// limit <= 0 means no limit, it returns the number of removed entries
+func (s *Stream) TrimMinID(minID ID, limit int) int {
+	page, offset := s.search(minID)
+	n := offset
+	for i := 0; i < page; i++ {
+		n += len(s.pages[i])
+	}
+	return s.trim(n, limit)
+}
+
+func (s *Stream) trim(n int, limit int) int {
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	s.removeFirst(n)
+	return n
+}
+
+// ForEach visits entries in ascending order of id until cb returns false
+func (s *Stream) ForEach(cb func(entry *Entry) bool) {
+	for _, page := range s.pages {
+		for _, entry := range page {
+			if !cb(entry) {
+				return
+			}
+		}
+	}
+}
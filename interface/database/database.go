@@ -2,6 +2,8 @@ package database
 
 import (
 	"go-redis/interface/resp"
+	"go-redis/rdb"
+	"time"
 )
 
 //代表redis的业务核心
@@ -16,6 +18,15 @@ type Database interface {
 	Close()
 }
 
+// DBEngine is the embedding storage engine exposing more methods for complex application, e.g. aof rewrite
+type DBEngine interface {
+	Database
+	// ForEach traverses all keys in the given db, expiration is nil if the key has no ttl
+	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
+	// LoadRDB loads all keys from rdb decoder, e.g. the rdb preamble of aof
+	LoadRDB(dec *rdb.Decoder) error
+}
+
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct { //指代redis数据结构
 	Data interface{}
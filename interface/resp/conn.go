@@ -7,4 +7,38 @@ type Connection interface {
 	GetDBIndex() int
 	SelectDB(int) //切换库
 
+	// used for `Auth` command
+	IsAuthenticated() bool
+	SetAuthenticated(bool)
+	// used for acl, empty if the client never sent AUTH with a username
+	GetUser() string
+	SetUser(string)
+
+	// used for `Multi` command
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	AddTxError(err error)
+	GetTxErrors() []error
+	// GetWatching returns watched keys and their versions at the time of WATCH: db index -> key -> version
+	GetWatching() map[int]map[string]uint32
+	ClearWatching()
+
+	// used for pub/sub
+	Subscribe(channel string) bool
+	UnSubscribe(channel string) bool
+	PSubscribe(pattern string) bool
+	PUnSubscribe(pattern string) bool
+	SubsCount() int
+	GetChannels() []string
+	GetPatterns() []string
+	// Push writes data asynchronously, it returns false if the client is disconnected for being too slow
+	Push(b []byte) bool
+
+	// used for blocking commands like BLPOP
+	// Done returns a channel closed after the connection is closed
+	Done() <-chan struct{}
+	SetBlocked(bool)
+	IsBlocked() bool
 }
@@ -0,0 +1,206 @@
+// Package lock provides a table of striped read-write locks, keys are hashed to slots of the table
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	prime32 = uint32(16777619)
+)
+
+// Locks provides rw locks for key
+// 一个槽位可能对应多个 key，但只要按照固定顺序加锁就不会死锁
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// Make creates a new lock table, size will be rounded up to power of 2
+func Make(tableSize int) *Locks {
+	size := computeCapacity(tableSize)
+	table := make([]*sync.RWMutex, size)
+	for i := 0; i < size; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+func computeCapacity(param int) (size int) {
+	if param <= 16 {
+		return 16
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	if n < 0 {
+		return 1 << 30
+	}
+	return n + 1
+}
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	tableSize := uint32(len(locks.table))
+	return (tableSize - 1) & hashCode
+}
+
+// Lock obtains exclusive lock for writing
+func (locks *Locks) Lock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Lock()
+}
+
+// RLock obtains shared lock for reading
+func (locks *Locks) RLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RLock()
+}
+
+// UnLock release exclusive lock
+func (locks *Locks) UnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Unlock()
+}
+
+// RUnLock release shared lock
+func (locks *Locks) RUnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RUnlock()
+}
+
+// toLockIndices returns distinct slot indices of keys in sorted order
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		index := locks.spread(fnv32(key))
+		indexMap[index] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// Locks obtains multiple exclusive locks for writing
+// invoking Lock in loop may cause dead lock, please use Locks
+func (locks *Locks) Locks(keys ...string) {
+	indices := locks.toLockIndices(keys, false)
+	for _, index := range indices {
+		locks.table[index].Lock()
+	}
+}
+
+// RLocks obtains multiple shared locks for reading
+// invoking RLock in loop may cause dead lock, please use RLocks
+func (locks *Locks) RLocks(keys ...string) {
+	indices := locks.toLockIndices(keys, false)
+	for _, index := range indices {
+		locks.table[index].RLock()
+	}
+}
+
+// UnLocks releases multiple exclusive locks
+func (locks *Locks) UnLocks(keys ...string) {
+	indices := locks.toLockIndices(keys, true)
+	for _, index := range indices {
+		locks.table[index].Unlock()
+	}
+}
+
+// RUnLocks releases multiple shared locks
+func (locks *Locks) RUnLocks(keys ...string) {
+	indices := locks.toLockIndices(keys, true)
+	for _, index := range indices {
+		locks.table[index].RUnlock()
+	}
+}
+
+// RLockAll obtains shared locks of all slots, so no key can be written until RUnLockAll
+func (locks *Locks) RLockAll() {
+	for _, mu := range locks.table {
+		mu.RLock()
+	}
+}
+
+// RUnLockAll releases shared locks of all slots
+func (locks *Locks) RUnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].RUnlock()
+	}
+}
+
+// LockAll obtains exclusive locks of all slots, it is used by commands operating the whole db
+func (locks *Locks) LockAll() {
+	for _, mu := range locks.table {
+		mu.Lock()
+	}
+}
+
+// UnLockAll releases exclusive locks of all slots
+func (locks *Locks) UnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].Unlock()
+	}
+}
+
+// RWLocks locks write keys and read keys together. allow duplicate keys
+// a slot shared by a write key and a read key is locked exclusively
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	keys := append(append([]string{}, writeKeys...), readKeys...)
+	indices := locks.toLockIndices(keys, false)
+	writeIndexSet := locks.toIndexSet(writeKeys)
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Lock()
+		} else {
+			mu.RLock()
+		}
+	}
+}
+
+// RWUnLocks unlocks write keys and read keys together. allow duplicate keys
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	keys := append(append([]string{}, writeKeys...), readKeys...)
+	indices := locks.toLockIndices(keys, true)
+	writeIndexSet := locks.toIndexSet(writeKeys)
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Unlock()
+		} else {
+			mu.RUnlock()
+		}
+	}
+}
+
+func (locks *Locks) toIndexSet(keys []string) map[uint32]struct{} {
+	set := make(map[uint32]struct{}, len(keys))
+	for _, key := range keys {
+		set[locks.spread(fnv32(key))] = struct{}{}
+	}
+	return set
+}
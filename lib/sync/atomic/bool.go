@@ -19,3 +19,15 @@ func (b *Boolean) Set(v bool) {
 		atomic.StoreUint32((*uint32)(b), 0)
 	}
 }
+
+// CompareAndSwap executes the compare-and-swap operation, it returns true if the value is swapped
+func (b *Boolean) CompareAndSwap(old, new bool) bool {
+	return atomic.CompareAndSwapUint32((*uint32)(b), boolToUint32(old), boolToUint32(new))
+}
+
+func boolToUint32(v bool) uint32 {
+	if v {
+		return 1
+	}
+	return 0
+}
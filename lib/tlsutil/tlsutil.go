@@ -0,0 +1,80 @@
+// Package tlsutil builds tls configs of the server and peer connections from certificate files
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ServerConfig makes tls config for the tls port.
+// if caFile is not empty, client certificates are verified according to authClients, which is yes, no or optional
+func ServerConfig(certFile string, keyFile string, caFile string, authClients string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile == "" {
+		return conf, nil
+	}
+	conf.ClientCAs, err = loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(authClients) {
+	case "", "yes":
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		conf.ClientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients: %s", authClients)
+	}
+	return conf, nil
+}
+
+// ClientConfig makes tls config for peer connections, the server certificate is used as client certificate,
+// and peers are verified with caFile, or system roots if caFile is empty
+func ClientConfig(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"go-redis/acl"
 	"go-redis/config"
 	"go-redis/lib/logger"
+	"go-redis/lib/tlsutil"
 	"go-redis/resp/handler"
 	"go-redis/tcp"
 	"os"
@@ -23,6 +25,24 @@ func fileExists(filename string) bool {
 	return err == nil && !info.IsDir()
 }
 
+// makeTcpConfig makes addresses to listen, port 0 disables the plaintext port
+func makeTcpConfig() (*tcp.Config, error) {
+	cfg := &tcp.Config{}
+	if config.Properties.Port > 0 {
+		cfg.Address = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	}
+	if config.Properties.TlsPort > 0 {
+		tlsConfig, err := tlsutil.ServerConfig(config.Properties.TlsCertFile, config.Properties.TlsKeyFile,
+			config.Properties.TlsCaCertFile, config.Properties.TlsAuthClients)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TlsPort)
+		cfg.TLSConfig = tlsConfig
+	}
+	return cfg, nil
+}
+
 func main() {
 	logger.Setup(&logger.Settings{
 		Path:       "logs",
@@ -37,13 +57,17 @@ func main() {
 		config.Properties = defaultProperties
 	}
 
-	err := tcp.ListenAndServeWithSignal(
-		&tcp.Config{
-			Address: fmt.Sprintf("%s:%d",
-				config.Properties.Bind,
-				config.Properties.Port),
-		},
-		handler.MakeHandler())
+	if err := acl.Setup(config.Properties.RequirePass, config.Properties.AclFile); err != nil {
+		logger.Error("load acl failed: " + err.Error())
+		return
+	}
+
+	tcpConfig, err := makeTcpConfig()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	err = tcp.ListenAndServeWithSignal(tcpConfig, handler.MakeHandler())
 	if err != nil {
 		logger.Error(err)
 	}
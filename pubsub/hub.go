@@ -0,0 +1,148 @@
+// Package pubsub implements publish/subscribe of channels and patterns
+package pubsub
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/wildcard"
+	"sync"
+)
+
+// patternSubscribers holds subscribers of a pattern
+type patternSubscribers struct {
+	matcher     *wildcard.Pattern
+	subscribers map[resp.Connection]struct{}
+}
+
+// Hub stores subscribers of channels and patterns
+type Hub struct {
+	// mu 同时保护订阅关系和消息的入队顺序：订阅回复和消息在锁内入队，客户端总是先收到订阅回复
+	mu       sync.RWMutex
+	channels map[string]map[resp.Connection]struct{}
+	patterns map[string]*patternSubscribers
+}
+
+// MakeHub creates an empty hub
+func MakeHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[resp.Connection]struct{}),
+		patterns: make(map[string]*patternSubscribers),
+	}
+}
+
+// caller should hold hub.mu
+func (hub *Hub) subscribe(c resp.Connection, channel string) {
+	subscribers, ok := hub.channels[channel]
+	if !ok {
+		subscribers = make(map[resp.Connection]struct{})
+		hub.channels[channel] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+// caller should hold hub.mu
+func (hub *Hub) unsubscribe(c resp.Connection, channel string) {
+	subscribers, ok := hub.channels[channel]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(hub.channels, channel)
+	}
+}
+
+// caller should hold hub.mu
+func (hub *Hub) psubscribe(c resp.Connection, pattern string) {
+	ps, ok := hub.patterns[pattern]
+	if !ok {
+		ps = &patternSubscribers{
+			matcher:     wildcard.CompilePattern(pattern),
+			subscribers: make(map[resp.Connection]struct{}),
+		}
+		hub.patterns[pattern] = ps
+	}
+	ps.subscribers[c] = struct{}{}
+}
+
+// caller should hold hub.mu
+func (hub *Hub) punsubscribe(c resp.Connection, pattern string) {
+	ps, ok := hub.patterns[pattern]
+	if !ok {
+		return
+	}
+	delete(ps.subscribers, c)
+	if len(ps.subscribers) == 0 {
+		delete(hub.patterns, pattern)
+	}
+}
+
+// Publish sends message to subscribers of the channel and matched patterns, returns the number of receivers.
+// messages are queued by Connection.Push, so a slow subscriber never blocks publisher
+func (hub *Hub) Publish(channel string, message []byte) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	count := 0
+	if subscribers, ok := hub.channels[channel]; ok {
+		data := makeMessage(channel, message)
+		for c := range subscribers {
+			c.Push(data)
+			count++
+		}
+	}
+	for pattern, ps := range hub.patterns {
+		if !ps.matcher.IsMatch(channel) {
+			continue
+		}
+		data := makePMessage(pattern, channel, message)
+		for c := range ps.subscribers {
+			c.Push(data)
+			count++
+		}
+	}
+	return count
+}
+
+// UnsubscribeAll removes the client from all channels and patterns, it is called after client closed
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, channel := range c.GetChannels() {
+		c.UnSubscribe(channel)
+		hub.unsubscribe(c, channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		c.PUnSubscribe(pattern)
+		hub.punsubscribe(c, pattern)
+	}
+}
+
+// Channels returns active channels matching the pattern, all active channels if pattern is empty
+func (hub *Hub) Channels(pattern string) []string {
+	var matcher *wildcard.Pattern
+	if pattern != "" {
+		matcher = wildcard.CompilePattern(pattern)
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	channels := make([]string, 0)
+	for channel := range hub.channels {
+		if matcher == nil || matcher.IsMatch(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// NumSub returns the number of subscribers of the channel, pattern subscribers are not counted
+func (hub *Hub) NumSub(channel string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.channels[channel])
+}
+
+// NumPat returns the number of patterns subscribed by all clients
+func (hub *Hub) NumPat() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.patterns)
+}
@@ -0,0 +1,225 @@
+package pubsub
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+var (
+	subscribeBytes    = []byte("subscribe")
+	unsubscribeBytes  = []byte("unsubscribe")
+	psubscribeBytes   = []byte("psubscribe")
+	punsubscribeBytes = []byte("punsubscribe")
+	messageBytes      = []byte("message")
+	pmessageBytes     = []byte("pmessage")
+)
+
+func makeMessage(channel string, message []byte) []byte {
+	return reply.MakeMultiBulkReply([][]byte{messageBytes, []byte(channel), message}).ToBytes()
+}
+
+func makePMessage(pattern string, channel string, message []byte) []byte {
+	return reply.MakeMultiBulkReply([][]byte{pmessageBytes, []byte(pattern), []byte(channel), message}).ToBytes()
+}
+
+// makeSubsReply makes the reply of subscribe family commands, name is nil if the client has nothing to unsubscribe
+func makeSubsReply(kind []byte, name []byte, count int) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply(kind),
+		reply.MakeBulkReply(name),
+		reply.MakeIntReply(int64(count)),
+	})
+}
+
+// subsReplier sends replies of subscribe family commands, one for each channel or pattern.
+// they are pushed to keep the order with messages, but within EXEC they are collected as the reply of the command,
+// otherwise they would be sent before the reply of EXEC
+type subsReplier struct {
+	c       resp.Connection
+	replies []resp.Reply
+}
+
+func (r *subsReplier) send(kind []byte, name []byte, count int) {
+	if r.c.InMultiState() {
+		r.replies = append(r.replies, makeSubsReply(kind, name, count))
+		return
+	}
+	r.c.Push(makeSubsReply(kind, name, count).ToBytes())
+}
+
+func (r *subsReplier) reply() resp.Reply {
+	switch len(r.replies) {
+	case 0:
+		return &reply.NoReply{}
+	case 1:
+		return r.replies[0]
+	}
+	return reply.MakeMultiRawReply(r.replies)
+}
+
+// subscribeModeCommands are commands allowed in subscribe mode
+var subscribeModeCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+}
+
+// CheckSubscribeMode returns an error if the client is in subscribe mode and the command is not allowed in it
+func CheckSubscribeMode(c resp.Connection, cmdName string) resp.Reply {
+	if c.SubsCount() == 0 || subscribeModeCommands[cmdName] {
+		return nil
+	}
+	return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+		"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+}
+
+// Ping replies PING in subscribe mode, which is a multi bulk of "pong" and the argument
+func Ping(args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("ping")
+	}
+	message := []byte{}
+	if len(args) == 1 {
+		message = args[0]
+	}
+	return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+}
+
+// Subscribe puts the client into subscribe mode, SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("subscribe")
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	r := &subsReplier{c: c}
+	for _, arg := range args {
+		channel := string(arg)
+		if c.Subscribe(channel) {
+			hub.subscribe(c, channel)
+		}
+		r.send(subscribeBytes, arg, c.SubsCount())
+	}
+	return r.reply()
+}
+
+// UnSubscribe removes the client from the channels, or all channels if no channel given, UNSUBSCRIBE [channel ...]
+func UnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	r := &subsReplier{c: c}
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+	if len(channels) == 0 {
+		r.send(unsubscribeBytes, nil, c.SubsCount())
+		return r.reply()
+	}
+	for _, channel := range channels {
+		if c.UnSubscribe(channel) {
+			hub.unsubscribe(c, channel)
+		}
+		r.send(unsubscribeBytes, []byte(channel), c.SubsCount())
+	}
+	return r.reply()
+}
+
+// PSubscribe subscribes channels matching the patterns, PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("psubscribe")
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	r := &subsReplier{c: c}
+	for _, arg := range args {
+		pattern := string(arg)
+		if c.PSubscribe(pattern) {
+			hub.psubscribe(c, pattern)
+		}
+		r.send(psubscribeBytes, arg, c.SubsCount())
+	}
+	return r.reply()
+}
+
+// PUnSubscribe removes the client from the patterns, or all patterns if no pattern given, PUNSUBSCRIBE [pattern ...]
+func PUnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	r := &subsReplier{c: c}
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, arg := range args {
+			patterns[i] = string(arg)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+	if len(patterns) == 0 {
+		r.send(punsubscribeBytes, nil, c.SubsCount())
+		return r.reply()
+	}
+	for _, pattern := range patterns {
+		if c.PUnSubscribe(pattern) {
+			hub.punsubscribe(c, pattern)
+		}
+		r.send(punsubscribeBytes, []byte(pattern), c.SubsCount())
+	}
+	return r.reply()
+}
+
+// Publish sends message to the channel, PUBLISH channel message
+func Publish(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	count := hub.Publish(string(args[0]), args[1])
+	return reply.MakeIntReply(int64(count))
+}
+
+// PubSub inspects the hub, PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func PubSub(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "channels":
+		if len(args) > 1 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		pattern := ""
+		if len(args) == 1 {
+			pattern = string(args[0])
+		}
+		channels := hub.Channels(pattern)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		replies := make([]resp.Reply, 0, len(args)*2)
+		for _, arg := range args {
+			replies = append(replies, reply.MakeBulkReply(arg), reply.MakeIntReply(int64(hub.NumSub(string(arg)))))
+		}
+		return reply.MakeMultiRawReply(replies)
+	case "numpat":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(hub.NumPat()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+}
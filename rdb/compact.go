@@ -0,0 +1,274 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// compact encodings used by redis for small objects: ziplist, listpack and intset
+
+var errCorruptCompact = errors.New("rdb: corrupt compact encoding")
+
+// decodeCompact decodes a value stored as a blob of compact encoding
+func decodeCompact(valueType byte, blob []byte, obj *Object) error {
+	var entries [][]byte
+	var err error
+	switch valueType {
+	case typeListZip, typeZSetZip, typeHashZip:
+		entries, err = parseZiplist(blob)
+	case typeIntset:
+		entries, err = parseIntset(blob)
+	default:
+		entries, err = parseListpack(blob)
+	}
+	if err != nil {
+		return err
+	}
+	switch valueType {
+	case typeListZip:
+		obj.Type, obj.Value = ListType, entries
+	case typeIntset, typeSetListpack:
+		obj.Type, obj.Value = SetType, entries
+	case typeHashZip, typeHashListpack:
+		if len(entries)%2 != 0 {
+			return errCorruptCompact
+		}
+		hash := make(map[string][]byte, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			hash[string(entries[i])] = entries[i+1]
+		}
+		obj.Type, obj.Value = HashType, hash
+	case typeZSetZip, typeZSetListpack:
+		if len(entries)%2 != 0 {
+			return errCorruptCompact
+		}
+		zset := make([]*ZSetEntry, 0, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+			if err != nil {
+				return err
+			}
+			zset = append(zset, &ZSetEntry{
+				Member: string(entries[i]),
+				Score:  score,
+			})
+		}
+		obj.Type, obj.Value = ZSetType, zset
+	}
+	return nil
+}
+
+// parseIntset reads intset: encoding(4 bytes) length(4 bytes) and little endian integers
+func parseIntset(blob []byte) ([][]byte, error) {
+	if len(blob) < 8 {
+		return nil, errCorruptCompact
+	}
+	encoding := int(binary.LittleEndian.Uint32(blob))
+	length := int(binary.LittleEndian.Uint32(blob[4:]))
+	if encoding != 2 && encoding != 4 && encoding != 8 || len(blob) < 8+encoding*length {
+		return nil, errCorruptCompact
+	}
+	result := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		p := blob[8+i*encoding:]
+		var value int64
+		switch encoding {
+		case 2:
+			value = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			value = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			value = int64(binary.LittleEndian.Uint64(p))
+		}
+		result = append(result, []byte(strconv.FormatInt(value, 10)))
+	}
+	return result, nil
+}
+
+// parseZiplist reads ziplist: zlbytes(4) zltail(4) zllen(2) entries... 0xff
+// each entry is: prevlen encoding data
+func parseZiplist(blob []byte) ([][]byte, error) {
+	if len(blob) < 11 {
+		return nil, errCorruptCompact
+	}
+	pos := 10
+	var result [][]byte
+	for {
+		if pos >= len(blob) {
+			return nil, errCorruptCompact
+		}
+		if blob[pos] == 0xff {
+			return result, nil
+		}
+		// prevlen
+		if blob[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(blob) {
+			return nil, errCorruptCompact
+		}
+		entry, n, err := readZiplistEntry(blob[pos:])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+		pos += n
+	}
+}
+
+// readZiplistEntry reads encoding and data of an entry, returns the entry and the number of bytes consumed
+func readZiplistEntry(p []byte) ([]byte, int, error) {
+	header := p[0]
+	switch header >> 6 {
+	case 0: // string, 6 bit length
+		return sliceString(p, 1, int(header&0x3f))
+	case 1: // string, 14 bit length
+		if len(p) < 2 {
+			return nil, 0, errCorruptCompact
+		}
+		return sliceString(p, 2, int(header&0x3f)<<8|int(p[1]))
+	case 2: // string, 32 bit length
+		if len(p) < 5 {
+			return nil, 0, errCorruptCompact
+		}
+		return sliceString(p, 5, int(binary.BigEndian.Uint32(p[1:])))
+	}
+	// integers
+	var value int64
+	var size int
+	switch header {
+	case 0xc0:
+		size = 2
+	case 0xd0:
+		size = 4
+	case 0xe0:
+		size = 8
+	case 0xf0:
+		size = 3
+	case 0xfe:
+		size = 1
+	default:
+		if header >= 0xf1 && header <= 0xfd {
+			return []byte(strconv.Itoa(int(header&0x0f) - 1)), 1, nil
+		}
+		return nil, 0, fmt.Errorf("rdb: unknown ziplist encoding 0x%x", header)
+	}
+	if len(p) < 1+size {
+		return nil, 0, errCorruptCompact
+	}
+	value = readIntLE(p[1 : 1+size])
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+// parseListpack reads listpack: total bytes(4) num elements(2) entries... 0xff
+// each entry is: encoding data backlen
+func parseListpack(blob []byte) ([][]byte, error) {
+	if len(blob) < 7 {
+		return nil, errCorruptCompact
+	}
+	pos := 6
+	var result [][]byte
+	for {
+		if pos >= len(blob) {
+			return nil, errCorruptCompact
+		}
+		if blob[pos] == 0xff {
+			return result, nil
+		}
+		entry, n, err := readListpackEntry(blob[pos:])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+		pos += n + listpackBacklenSize(n)
+	}
+}
+
+// readListpackEntry reads encoding and data of an entry, returns the entry and the size of encoding and data
+func readListpackEntry(p []byte) ([]byte, int, error) {
+	header := p[0]
+	switch {
+	case header&0x80 == 0: // 7 bit uint
+		return []byte(strconv.Itoa(int(header & 0x7f))), 1, nil
+	case header&0xc0 == 0x80: // 6 bit string length
+		return sliceString(p, 1, int(header&0x3f))
+	case header&0xe0 == 0xc0: // 13 bit int
+		if len(p) < 2 {
+			return nil, 0, errCorruptCompact
+		}
+		value := int(header&0x1f)<<8 | int(p[1])
+		if value >= 1<<12 {
+			value -= 1 << 13
+		}
+		return []byte(strconv.Itoa(value)), 2, nil
+	case header&0xf0 == 0xe0: // 12 bit string length
+		if len(p) < 2 {
+			return nil, 0, errCorruptCompact
+		}
+		return sliceString(p, 2, int(header&0x0f)<<8|int(p[1]))
+	}
+	var size int
+	switch header {
+	case 0xf0: // 32 bit string length
+		if len(p) < 5 {
+			return nil, 0, errCorruptCompact
+		}
+		return sliceString(p, 5, int(binary.LittleEndian.Uint32(p[1:])))
+	case 0xf1:
+		size = 2
+	case 0xf2:
+		size = 3
+	case 0xf3:
+		size = 4
+	case 0xf4:
+		size = 8
+	default:
+		return nil, 0, fmt.Errorf("rdb: unknown listpack encoding 0x%x", header)
+	}
+	if len(p) < 1+size {
+		return nil, 0, errCorruptCompact
+	}
+	value := readIntLE(p[1 : 1+size])
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+// listpackBacklenSize returns bytes used by backlen of an entry whose encoding and data take n bytes
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// sliceString returns p[offset:offset+length] and the total size
+func sliceString(p []byte, offset int, length int) ([]byte, int, error) {
+	if length < 0 || len(p) < offset+length {
+		return nil, 0, errCorruptCompact
+	}
+	result := make([]byte, length)
+	copy(result, p[offset:offset+length])
+	return result, offset + length, nil
+}
+
+// readIntLE reads a signed little endian integer of 1 to 8 bytes
+func readIntLE(p []byte) int64 {
+	var value uint64
+	for i := len(p) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(p[i])
+	}
+	// sign extension
+	shift := uint(64 - 8*len(p))
+	return int64(value<<shift) >> shift
+}
@@ -0,0 +1,45 @@
+package rdb
+
+import (
+	"hash"
+	"hash/crc64"
+)
+
+// redis uses crc-64-jones: reflected, polynomial 0xad93d23594c935a9, init 0 and no final xor.
+// hash/crc64 takes the reversed polynomial, and it inverts crc before and after update,
+// so the crc is inverted once more to get init 0 and no final xor.
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+type jonesDigest struct {
+	crc uint64
+}
+
+func newCRC64() hash.Hash64 {
+	return &jonesDigest{}
+}
+
+func (d *jonesDigest) Write(p []byte) (int, error) {
+	d.crc = ^crc64.Update(^d.crc, jonesTable, p)
+	return len(p), nil
+}
+
+func (d *jonesDigest) Sum64() uint64 {
+	return d.crc
+}
+
+func (d *jonesDigest) Sum(in []byte) []byte {
+	s := d.Sum64()
+	return append(in, byte(s>>56), byte(s>>48), byte(s>>40), byte(s>>32), byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
+
+func (d *jonesDigest) Reset() {
+	d.crc = 0
+}
+
+func (d *jonesDigest) Size() int {
+	return crc64.Size
+}
+
+func (d *jonesDigest) BlockSize() int {
+	return 1
+}
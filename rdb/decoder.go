@@ -0,0 +1,476 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Decoder reads objects from rdb
+type Decoder struct {
+	reader *bufio.Reader
+	crc    hash.Hash64
+	buf    []byte
+	// version of the rdb being read
+	version int
+	// offset is the number of bytes read
+	offset int64
+}
+
+// NewDecoder creates a decoder reading from r
+// the decoder may read more data than the rdb from r because of buffering,
+// pass a *bufio.Reader to continue reading the data after rdb from it
+func NewDecoder(r io.Reader) *Decoder {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
+	return &Decoder{
+		reader: reader,
+		crc:    newCRC64(),
+		buf:    make([]byte, 8),
+	}
+}
+
+// Offset returns the number of bytes read, after Parse returns nil it is the size of rdb
+func (dec *Decoder) Offset() int64 {
+	return dec.offset
+}
+
+// ErrUnsupportedType is returned for value types we cannot load, e.g. module
+var ErrUnsupportedType = errors.New("rdb: unsupported value type")
+
+// Parse reads the whole rdb and calls cb for each object, it stops if cb returns false
+func (dec *Decoder) Parse(cb func(o *Object) bool) error {
+	if err := dec.readHeader(); err != nil {
+		return err
+	}
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return dec.verifyChecksum()
+		case opCodeSelectDB:
+			index, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opCodeResizeDB:
+			if _, err := dec.readLength(); err != nil {
+				return err
+			}
+			if _, err := dec.readLength(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireTimeMs:
+			if err := dec.readFull(dec.buf); err != nil {
+				return err
+			}
+			t := time.Unix(0, int64(binary.LittleEndian.Uint64(dec.buf))*int64(time.Millisecond))
+			expiration = &t
+		case opCodeExpireTime:
+			if err := dec.readFull(dec.buf[:4]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf)), 0)
+			expiration = &t
+		case opCodeFreq:
+			if _, err := dec.readByte(); err != nil {
+				return err
+			}
+		case opCodeIdle:
+			if _, err := dec.readLength(); err != nil {
+				return err
+			}
+		case opCodeFunction2:
+			// functions are not supported, skip the library code
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+		case opCodeModuleAux:
+			return fmt.Errorf("%w: module aux", ErrUnsupportedType)
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			obj := &Object{
+				DB:         dbIndex,
+				Key:        string(key),
+				Expiration: expiration,
+			}
+			if err := dec.readObject(opCode, obj); err != nil {
+				return err
+			}
+			expiration = nil
+			if !cb(obj) {
+				return nil
+			}
+		}
+	}
+}
+
+func (dec *Decoder) readHeader() error {
+	header := make([]byte, 9)
+	if err := dec.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:5]) != Magic {
+		return errors.New("rdb: wrong signature")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return errors.New("rdb: invalid version")
+	}
+	dec.version = version
+	return nil
+}
+
+// verifyChecksum reads the checksum after EOF, a zero checksum means checksum is disabled
+func (dec *Decoder) verifyChecksum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc.Sum64()
+	n, err := io.ReadFull(dec.reader, dec.buf)
+	dec.offset += int64(n)
+	if err != nil {
+		return err
+	}
+	actual := binary.LittleEndian.Uint64(dec.buf)
+	if actual != 0 && actual != expected {
+		return errors.New("rdb: wrong checksum")
+	}
+	return nil
+}
+
+// readFull reads len(p) bytes, all data read before checksum should be read through readFull or readByte
+func (dec *Decoder) readFull(p []byte) error {
+	n, err := io.ReadFull(dec.reader, p)
+	dec.offset += int64(n)
+	if err != nil {
+		return err
+	}
+	_, _ = dec.crc.Write(p)
+	return nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	b, err := dec.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	dec.offset++
+	_, _ = dec.crc.Write([]byte{b})
+	return b, nil
+}
+
+// readLength reads length encoding, it returns error for special encoding
+func (dec *Decoder) readLength() (uint64, error) {
+	length, special, err := dec.readLengthWithEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if special {
+		return 0, errors.New("rdb: unexpected special encoding")
+	}
+	return length, nil
+}
+
+// readLengthWithEncoding reads length encoding,
+// if special is true, the returned value is the format of a specially encoded string
+func (dec *Decoder) readLengthWithEncoding() (length uint64, special bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0: // 6 bit length
+		return uint64(first & 0x3f), false, nil
+	case 1: // 14 bit length
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch first {
+		case 0x80:
+			if err := dec.readFull(dec.buf[:4]); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(dec.buf)), false, nil
+		case 0x81:
+			if err := dec.readFull(dec.buf); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(dec.buf), false, nil
+		}
+		return 0, false, fmt.Errorf("rdb: unknown length encoding 0x%x", first)
+	default:
+		return uint64(first & 0x3f), true, nil
+	}
+}
+
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// readString reads string encoding, including integer and lzf encoded strings
+func (dec *Decoder) readString() ([]byte, error) {
+	length, special, err := dec.readLengthWithEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		result := make([]byte, length)
+		if err := dec.readFull(result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encInt16:
+		if err := dec.readFull(dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf))))), nil
+	case encInt32:
+		if err := dec.readFull(dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf))))), nil
+	case encLZF:
+		compressedLen, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, compressedLen)
+		if err := dec.readFull(compressed); err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("rdb: unknown string encoding %d", length)
+}
+
+// readFloat reads score of zset in string format
+func (dec *Decoder) readFloat() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	if err := dec.readFull(buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readBinaryFloat reads score of zset in binary format
+func (dec *Decoder) readBinaryFloat() (float64, error) {
+	if err := dec.readFull(dec.buf); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf)), nil
+}
+
+// readObject reads value of the given type into obj
+func (dec *Decoder) readObject(valueType byte, obj *Object) error {
+	switch valueType {
+	case typeString:
+		value, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = StringType, value
+	case typeList, typeSet:
+		values, err := dec.readStringList()
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = ListType, values
+		if valueType == typeSet {
+			obj.Type = SetType
+		}
+	case typeZSet, typeZSet2:
+		entries, err := dec.readZSet(valueType == typeZSet2)
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = ZSetType, entries
+	case typeHash:
+		hash, err := dec.readHash()
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = HashType, hash
+	case typeListQuicklist, typeListQuicklist2:
+		values, err := dec.readQuicklist(valueType == typeListQuicklist2)
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = ListType, values
+	case typeListZip, typeZSetZip, typeHashZip, typeHashListpack, typeZSetListpack, typeSetListpack, typeIntset:
+		blob, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		return decodeCompact(valueType, blob, obj)
+	case typeStream, typeStreamListpacks2, typeStreamListpacks3:
+		value, err := dec.readStream(valueType)
+		if err != nil {
+			return err
+		}
+		obj.Type, obj.Value = StreamType, value
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedType, valueType)
+	}
+	return nil
+}
+
+func (dec *Decoder) readStringList() ([][]byte, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, size)
+	for i := uint64(0); i < size; i++ {
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (dec *Decoder) readHash() (map[string][]byte, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := make(map[string][]byte, size)
+	for i := uint64(0); i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash[string(field)] = value
+	}
+	return hash, nil
+}
+
+func (dec *Decoder) readZSet(binaryScore bool) ([]*ZSetEntry, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*ZSetEntry, 0, size)
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = dec.readBinaryFloat()
+		} else {
+			score, err = dec.readFloat()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ZSetEntry{
+			Member: string(member),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
+
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// readQuicklist reads list in quicklist, nodes are ziplists, or listpacks and plain elements in version 2
+func (dec *Decoder) readQuicklist(v2 bool) ([][]byte, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for i := uint64(0); i < size; i++ {
+		container := uint64(quicklistNodePacked)
+		if v2 {
+			container, err = dec.readLength()
+			if err != nil {
+				return nil, err
+			}
+		}
+		blob, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quicklistNodePlain {
+			values = append(values, blob)
+			continue
+		}
+		var entries [][]byte
+		if v2 {
+			entries, err = parseListpack(blob)
+		} else {
+			entries, err = parseZiplist(blob)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, entries...)
+	}
+	return values, nil
+}
@@ -0,0 +1,268 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Encoder writes objects in rdb format
+// Usage: WriteHeader, then WriteAux and WriteDBHeader, then objects of the db, finally WriteEnd
+type Encoder struct {
+	writer *bufio.Writer
+	crc    hash.Hash64
+	buf    []byte
+}
+
+// NewEncoder creates an encoder writing into w, WriteEnd flushes the buffered data
+func NewEncoder(w io.Writer) *Encoder {
+	crc := newCRC64()
+	return &Encoder{
+		writer: bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc:    crc,
+		buf:    make([]byte, 8),
+	}
+}
+
+func (enc *Encoder) write(p []byte) error {
+	_, err := enc.writer.Write(p)
+	return err
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	return enc.writer.WriteByte(b)
+}
+
+// WriteHeader writes Magic and version
+func (enc *Encoder) WriteHeader() error {
+	return enc.write([]byte(Magic + strconv.Itoa(Version + 10000)[1:]))
+}
+
+// WriteAux writes an auxiliary field, e.g. redis-ver
+func (enc *Encoder) WriteAux(key string, value string) error {
+	if err := enc.writeByte(opCodeAux); err != nil {
+		return err
+	}
+	if err := enc.writeString(key); err != nil {
+		return err
+	}
+	return enc.writeString(value)
+}
+
+// WriteDBHeader selects db and hints sizes of its hash tables
+func (enc *Encoder) WriteDBHeader(dbIndex int, keyCount int, ttlCount int) error {
+	if err := enc.writeByte(opCodeSelectDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if err := enc.writeByte(opCodeResizeDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(keyCount)); err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(ttlCount))
+}
+
+// WriteEnd writes EOF and checksum, then flushes the buffer
+func (enc *Encoder) WriteEnd() error {
+	if err := enc.writeByte(opCodeEOF); err != nil {
+		return err
+	}
+	// checksum covers everything before it, flush to feed crc
+	if err := enc.writer.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf, enc.crc.Sum64())
+	if err := enc.write(enc.buf); err != nil {
+		return err
+	}
+	return enc.writer.Flush()
+}
+
+// WriteStringObject writes a string key
+func (enc *Encoder) WriteStringObject(key string, value []byte, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeString, expiration); err != nil {
+		return err
+	}
+	return enc.writeBytes(value)
+}
+
+// WriteListObject writes a list key
+func (enc *Encoder) WriteListObject(key string, values [][]byte, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeList, expiration); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(len(values))); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := enc.writeBytes(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSetObject writes a set key
+func (enc *Encoder) WriteSetObject(key string, members [][]byte, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeSet, expiration); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(len(members))); err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := enc.writeBytes(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteHashObject writes a hash key
+func (enc *Encoder) WriteHashObject(key string, hash map[string][]byte, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeHash, expiration); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(len(hash))); err != nil {
+		return err
+	}
+	for field, value := range hash {
+		if err := enc.writeString(field); err != nil {
+			return err
+		}
+		if err := enc.writeBytes(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteZSetObject writes a sorted set key, scores are written in binary
+func (enc *Encoder) WriteZSetObject(key string, entries []*ZSetEntry, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeZSet2, expiration); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := enc.writeString(entry.Member); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf, math.Float64bits(entry.Score))
+		if err := enc.write(enc.buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteObject writes an object read by Decoder or built by caller, see Object for types of value
+func (enc *Encoder) WriteObject(obj *Object) error {
+	switch obj.Type {
+	case StringType:
+		return enc.WriteStringObject(obj.Key, obj.Value.([]byte), obj.Expiration)
+	case ListType:
+		return enc.WriteListObject(obj.Key, obj.Value.([][]byte), obj.Expiration)
+	case SetType:
+		return enc.WriteSetObject(obj.Key, obj.Value.([][]byte), obj.Expiration)
+	case HashType:
+		return enc.WriteHashObject(obj.Key, obj.Value.(map[string][]byte), obj.Expiration)
+	case ZSetType:
+		return enc.WriteZSetObject(obj.Key, obj.Value.([]*ZSetEntry), obj.Expiration)
+	case StreamType:
+		return enc.WriteStreamObject(obj.Key, obj.Value.(*StreamValue), obj.Expiration)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedType, obj.Type)
+}
+
+// beginObject writes expiration, value type and key
+func (enc *Encoder) beginObject(key string, valueType byte, expiration *time.Time) error {
+	if expiration != nil {
+		if err := enc.writeByte(opCodeExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf, uint64(expiration.UnixNano()/int64(time.Millisecond)))
+		if err := enc.write(enc.buf); err != nil {
+			return err
+		}
+	}
+	if err := enc.writeByte(valueType); err != nil {
+		return err
+	}
+	return enc.writeString(key)
+}
+
+// writeLength writes length encoding
+func (enc *Encoder) writeLength(length uint64) error {
+	switch {
+	case length < 1<<6:
+		return enc.writeByte(byte(length))
+	case length < 1<<14:
+		return enc.write([]byte{byte(length>>8) | 0x40, byte(length)})
+	case length <= math.MaxUint32:
+		if err := enc.writeByte(0x80); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(enc.buf, uint32(length))
+		return enc.write(enc.buf[:4])
+	default:
+		if err := enc.writeByte(0x81); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(enc.buf, length)
+		return enc.write(enc.buf)
+	}
+}
+
+func (enc *Encoder) writeString(s string) error {
+	return enc.writeBytes([]byte(s))
+}
+
+// writeBytes writes string encoding, strings look like small integers are stored as integers
+func (enc *Encoder) writeBytes(b []byte) error {
+	if len(b) <= 11 {
+		if err := enc.tryWriteInt(b); err == nil {
+			return nil
+		} else if err != errNotInt {
+			return err
+		}
+	}
+	if err := enc.writeLength(uint64(len(b))); err != nil {
+		return err
+	}
+	return enc.write(b)
+}
+
+var errNotInt = errors.New("not int")
+
+// tryWriteInt writes integer encoding if b is an integer in canonical form
+func (enc *Encoder) tryWriteInt(b []byte) error {
+	value, err := strconv.ParseInt(string(b), 10, 32)
+	if err != nil || strconv.FormatInt(value, 10) != string(b) {
+		return errNotInt
+	}
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		return enc.write([]byte{0xc0, byte(value)})
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		binary.LittleEndian.PutUint16(enc.buf, uint16(value))
+		return enc.write([]byte{0xc1, enc.buf[0], enc.buf[1]})
+	default:
+		if err := enc.writeByte(0xc2); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(enc.buf, uint32(value))
+		return enc.write(enc.buf[:4])
+	}
+}
@@ -0,0 +1,51 @@
+package rdb
+
+import "errors"
+
+var errCorruptLZF = errors.New("rdb: corrupt lzf compressed string")
+
+// lzfDecompress decompresses lzf compressed data, outLen is the length of uncompressed data
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < 1<<5 {
+			// literal run of ctrl+1 bytes
+			ctrl++
+			if ip+ctrl > len(in) {
+				return nil, errCorruptLZF
+			}
+			out = append(out, in[ip:ip+ctrl]...)
+			ip += ctrl
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errCorruptLZF
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errCorruptLZF
+		}
+		ref -= int(in[ip])
+		ip++
+		length += 2
+		if ref < 0 {
+			return nil, errCorruptLZF
+		}
+		// 引用区域可能和输出区域重叠，只能逐字节复制
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errCorruptLZF
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+// Package rdb encodes and decodes snapshots in redis RDB format,
+// so that dumps can be exchanged with redis and its tools.
+package rdb
+
+import "time"
+
+const (
+	// Version is the rdb version we write, it can be loaded by redis 5.0 and later
+	Version = 9
+
+	// Magic is the signature at the beginning of rdb file
+	Magic = "REDIS"
+	// RedisVersion is written as redis-ver aux field
+	RedisVersion = "6.2.0"
+)
+
+// value types
+const (
+	typeString  = 0
+	typeList    = 1
+	typeSet     = 2
+	typeZSet    = 3
+	typeHash    = 4
+	typeZSet2   = 5 // zset with binary double scores
+	typeZipmap  = 9
+	typeListZip = 10 // list in ziplist
+	typeIntset  = 11
+	typeZSetZip = 12 // zset in ziplist
+	typeHashZip = 13 // hash in ziplist
+	// list in quicklist, each node is a ziplist
+	typeListQuicklist = 14
+	typeStream        = 15
+	typeHashListpack  = 16
+	typeZSetListpack  = 17
+	// list in quicklist, each node is a listpack or a plain element
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+)
+
+// opcodes
+const (
+	opCodeFunction2    = 245
+	opCodeModuleAux    = 247
+	opCodeIdle         = 248
+	opCodeFreq         = 249
+	opCodeAux          = 250
+	opCodeResizeDB     = 251
+	opCodeExpireTimeMs = 252
+	opCodeExpireTime   = 253
+	opCodeSelectDB     = 254
+	opCodeEOF          = 255
+)
+
+// Object types
+const (
+	StringType = "string"
+	ListType   = "list"
+	SetType    = "set"
+	HashType   = "hash"
+	ZSetType   = "zset"
+	StreamType = "stream"
+)
+
+// ZSetEntry is a member of sorted set
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// StreamID identifies an entry of stream
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// StreamEntry is an entry of stream, Fields are field value pairs
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamValue is entries of stream in ascending order of id, and the greatest id ever added.
+// consumer groups are not supported, they are skipped when loading
+type StreamValue struct {
+	Entries []*StreamEntry
+	LastID  StreamID
+}
+
+// Object is a key-value pair read from rdb
+// Value is []byte for string, [][]byte for list and set, map[string][]byte for hash, []*ZSetEntry for zset
+// and *StreamValue for stream
+type Object struct {
+	DB         int
+	Key        string
+	Type       string
+	Expiration *time.Time // nil if the key has no ttl
+	Value      interface{}
+}
@@ -0,0 +1,406 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// streams are saved as RDB_TYPE_STREAM_LISTPACKS like redis 5:
+// a radix tree of listpacks keyed by the master id of each node, then length and last id of the stream.
+// each listpack begins with a master entry: count, deleted, master fields, 0,
+// then entries: flags, ms-diff, seq-diff, [num-fields, field, value ...] | [value ...], lp-count
+
+// streamNodeEntries is the max number of entries in a node, the same as redis default stream-node-max-entries
+const streamNodeEntries = 100
+
+const (
+	streamItemFlagDeleted    = 1 << 0
+	streamItemFlagSameFields = 1 << 1
+)
+
+var errCorruptStream = errors.New("rdb: corrupt stream")
+
+// WriteStreamObject writes a stream key
+func (enc *Encoder) WriteStreamObject(key string, value *StreamValue, expiration *time.Time) error {
+	if err := enc.beginObject(key, typeStream, expiration); err != nil {
+		return err
+	}
+	nodes := (len(value.Entries) + streamNodeEntries - 1) / streamNodeEntries
+	if err := enc.writeLength(uint64(nodes)); err != nil {
+		return err
+	}
+	for i := 0; i < len(value.Entries); i += streamNodeEntries {
+		end := i + streamNodeEntries
+		if end > len(value.Entries) {
+			end = len(value.Entries)
+		}
+		entries := value.Entries[i:end]
+		nodeKey := make([]byte, 16)
+		binary.BigEndian.PutUint64(nodeKey, entries[0].ID.Ms)
+		binary.BigEndian.PutUint64(nodeKey[8:], entries[0].ID.Seq)
+		// node key and listpack are raw strings, they must not be encoded as integers
+		if err := enc.writeLength(uint64(len(nodeKey))); err != nil {
+			return err
+		}
+		if err := enc.write(nodeKey); err != nil {
+			return err
+		}
+		lp := encodeStreamNode(entries)
+		if err := enc.writeLength(uint64(len(lp))); err != nil {
+			return err
+		}
+		if err := enc.write(lp); err != nil {
+			return err
+		}
+	}
+	if err := enc.writeLength(uint64(len(value.Entries))); err != nil {
+		return err
+	}
+	if err := enc.writeLength(value.LastID.Ms); err != nil {
+		return err
+	}
+	if err := enc.writeLength(value.LastID.Seq); err != nil {
+		return err
+	}
+	// no consumer groups
+	return enc.writeLength(0)
+}
+
+// encodeStreamNode encodes entries into a listpack, fields of the first entry are the master fields
+func encodeStreamNode(entries []*StreamEntry) []byte {
+	lp := &listpackWriter{}
+	master := entries[0]
+	masterFields := make([][]byte, 0, len(master.Fields)/2)
+	for i := 0; i < len(master.Fields); i += 2 {
+		masterFields = append(masterFields, master.Fields[i])
+	}
+	lp.appendInt(int64(len(entries))) // count
+	lp.appendInt(0)                   // deleted
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0) // end of master entry
+	for _, entry := range entries {
+		numFields := len(entry.Fields) / 2
+		sameFields := numFields == len(masterFields)
+		for i := 0; sameFields && i < numFields; i++ {
+			sameFields = string(entry.Fields[2*i]) == string(masterFields[i])
+		}
+		flags := int64(0)
+		if sameFields {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		// 差值可能超出 int64，按补码回绕，读取时相加即可还原
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		if sameFields {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+			lp.appendInt(int64(numFields + 3))
+		} else {
+			lp.appendInt(int64(numFields))
+			for _, b := range entry.Fields {
+				lp.appendString(b)
+			}
+			lp.appendInt(int64(2*numFields + 4))
+		}
+	}
+	return lp.bytes()
+}
+
+// readStream reads a stream of RDB_TYPE_STREAM_LISTPACKS and its later versions, consumer groups are skipped
+func (dec *Decoder) readStream(valueType byte) (*StreamValue, error) {
+	value := &StreamValue{}
+	nodes, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		nodeKey, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeKey) != 16 {
+			return nil, errCorruptStream
+		}
+		master := StreamID{
+			Ms:  binary.BigEndian.Uint64(nodeKey),
+			Seq: binary.BigEndian.Uint64(nodeKey[8:]),
+		}
+		blob, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements, err := parseListpack(blob)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := decodeStreamNode(master, elements)
+		if err != nil {
+			return nil, err
+		}
+		value.Entries = append(value.Entries, entries...)
+	}
+	// length, it equals the number of entries read
+	if _, err := dec.readLength(); err != nil {
+		return nil, err
+	}
+	if value.LastID.Ms, err = dec.readLength(); err != nil {
+		return nil, err
+	}
+	if value.LastID.Seq, err = dec.readLength(); err != nil {
+		return nil, err
+	}
+	if valueType >= typeStreamListpacks2 {
+		// first id, max deleted id and entries added
+		for j := 0; j < 5; j++ {
+			if _, err := dec.readLength(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := dec.skipConsumerGroups(valueType); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeStreamNode reads entries from elements of a listpack, deleted entries are skipped
+func decodeStreamNode(master StreamID, elements [][]byte) ([]*StreamEntry, error) {
+	pos := 0
+	next := func() (int64, error) {
+		if pos >= len(elements) {
+			return 0, errCorruptStream
+		}
+		v, err := strconv.ParseInt(string(elements[pos]), 10, 64)
+		pos++
+		return v, err
+	}
+	nextBytes := func() ([]byte, error) {
+		if pos >= len(elements) {
+			return nil, errCorruptStream
+		}
+		pos++
+		return elements[pos-1], nil
+	}
+	// master entry: count, deleted, master fields, 0
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+	numMasterFields, err := next()
+	if err != nil || numMasterFields < 0 || int(numMasterFields) > len(elements) {
+		return nil, errCorruptStream
+	}
+	masterFields := make([][]byte, numMasterFields)
+	for i := range masterFields {
+		if masterFields[i], err = nextBytes(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+
+	var entries []*StreamEntry
+	for pos < len(elements) {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		entry := &StreamEntry{
+			ID: StreamID{
+				Ms:  master.Ms + uint64(msDiff),
+				Seq: master.Seq + uint64(seqDiff),
+			},
+		}
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				value, err := nextBytes()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, field, value)
+			}
+		} else {
+			numFields, err := next()
+			if err != nil || numFields < 0 || int(numFields) > len(elements) {
+				return nil, errCorruptStream
+			}
+			for j := int64(0); j < 2*numFields; j++ {
+				b, err := nextBytes()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, b)
+			}
+		}
+		// lp-count
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// skipConsumerGroups reads and drops consumer groups, including their pending entries and consumers
+func (dec *Decoder) skipConsumerGroups(valueType byte) error {
+	groups, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	rawID := make([]byte, 16)
+	for i := uint64(0); i < groups; i++ {
+		if _, err := dec.readString(); err != nil { // name
+			return err
+		}
+		lengths := 2 // last id
+		if valueType >= typeStreamListpacks2 {
+			lengths++ // entries read
+		}
+		for j := 0; j < lengths; j++ {
+			if _, err := dec.readLength(); err != nil {
+				return err
+			}
+		}
+		pending, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			// id, delivery time in ms, delivery count
+			if err := dec.readFull(rawID); err != nil {
+				return err
+			}
+			if err := dec.readFull(dec.buf); err != nil {
+				return err
+			}
+			if _, err := dec.readLength(); err != nil {
+				return err
+			}
+		}
+		consumers, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := dec.readString(); err != nil { // name
+				return err
+			}
+			if err := dec.readFull(dec.buf); err != nil { // seen time
+				return err
+			}
+			if valueType >= typeStreamListpacks3 {
+				if err := dec.readFull(dec.buf); err != nil { // active time
+					return err
+				}
+			}
+			ids, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			for k := uint64(0); k < ids; k++ {
+				if err := dec.readFull(rawID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// listpackWriter builds a listpack: total bytes(4) num elements(2) entries... 0xff
+type listpackWriter struct {
+	entries []byte
+	count   int
+}
+
+// appendInt appends an integer in the smallest encoding
+func (lp *listpackWriter) appendInt(v int64) {
+	var entry []byte
+	switch {
+	case v >= 0 && v <= 127:
+		entry = []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		entry = []byte{0xc0 | byte(u>>8), byte(u)}
+	case v >= -1<<15 && v < 1<<15:
+		entry = []byte{0xf1, byte(v), byte(v >> 8)}
+	case v >= -1<<23 && v < 1<<23:
+		entry = []byte{0xf2, byte(v), byte(v >> 8), byte(v >> 16)}
+	case v >= -1<<31 && v < 1<<31:
+		entry = make([]byte, 5)
+		entry[0] = 0xf3
+		binary.LittleEndian.PutUint32(entry[1:], uint32(v))
+	default:
+		entry = make([]byte, 9)
+		entry[0] = 0xf4
+		binary.LittleEndian.PutUint64(entry[1:], uint64(v))
+	}
+	lp.appendEntry(entry)
+}
+
+// appendString appends a string, it is never converted into integer
+func (lp *listpackWriter) appendString(s []byte) {
+	var header []byte
+	switch n := len(s); {
+	case n < 1<<6:
+		header = []byte{0x80 | byte(n)}
+	case n < 1<<12:
+		header = []byte{0xe0 | byte(n>>8), byte(n)}
+	default:
+		header = make([]byte, 5)
+		header[0] = 0xf0
+		binary.LittleEndian.PutUint32(header[1:], uint32(n))
+	}
+	lp.appendEntry(append(header, s...))
+}
+
+// appendEntry appends encoding and data of an entry, followed by its backlen
+func (lp *listpackWriter) appendEntry(entry []byte) {
+	lp.entries = append(lp.entries, entry...)
+	n := uint64(len(entry))
+	size := listpackBacklenSize(len(entry))
+	backlen := make([]byte, size)
+	// 高位在前，除第一个字节外最高位置 1，从后往前读取时可以找到结尾
+	for i := size - 1; i >= 0; i-- {
+		backlen[i] = byte(n & 127)
+		if i != 0 {
+			backlen[i] |= 128
+		}
+		n >>= 7
+	}
+	lp.entries = append(lp.entries, backlen...)
+	lp.count++
+}
+
+func (lp *listpackWriter) bytes() []byte {
+	total := 6 + len(lp.entries) + 1
+	result := make([]byte, 6, total)
+	binary.LittleEndian.PutUint32(result, uint32(total))
+	count := lp.count
+	if count > 65535 {
+		count = 65535 // unknown, readers count the entries
+	}
+	binary.LittleEndian.PutUint16(result[4:], uint16(count))
+	result = append(result, lp.entries...)
+	return append(result, 0xff)
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"crypto/tls"
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/sync/wait"
+	"go-redis/lib/utils"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
 	"net"
@@ -19,6 +21,10 @@ type Client struct {
 	waitingReqs chan *request // waiting response
 	ticker      *time.Ticker
 	addr        string
+	tlsConfig   *tls.Config // nil for plaintext connection
+	password    string      // sent by AUTH again after reconnecting
+	// heartbeatInterval is the interval of PING, it must be shorter than the idle timeout of server
+	heartbeatInterval time.Duration
 
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
 }
@@ -36,26 +42,49 @@ type request struct {
 const (
 	chanSize = 256
 	maxWait  = 3 * time.Second
+
+	// DefaultHeartbeatInterval is the interval of PING if not changed by SetHeartbeatInterval
+	DefaultHeartbeatInterval = 10 * time.Second
 )
 
 // MakeClient creates a new client
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	return MakeTLSClient(addr, nil)
+}
+
+// MakeTLSClient creates a new client over tls, tlsConfig nil means plaintext
+func MakeTLSClient(addr string, tlsConfig *tls.Config) (*Client, error) {
+	conn, err := dial(addr, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
 		addr:        addr,
+		tlsConfig:   tlsConfig,
 		conn:        conn,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
+
+		heartbeatInterval: DefaultHeartbeatInterval,
 	}, nil
 }
 
+func dial(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, tlsConfig)
+}
+
+// SetHeartbeatInterval changes the interval of PING, it should be called before Start
+func (client *Client) SetHeartbeatInterval(interval time.Duration) {
+	client.heartbeatInterval = interval
+}
+
 // Start starts asynchronous goroutines
 func (client *Client) Start() {
-	client.ticker = time.NewTicker(10 * time.Second)
+	client.ticker = time.NewTicker(client.heartbeatInterval)
 	go client.handleWrite()
 	go func() {
 		err := client.handleRead()
@@ -91,12 +120,20 @@ func (client *Client) handleConnectionError(err error) error {
 			return err1
 		}
 	}
-	conn, err1 := net.Dial("tcp", client.addr)
+	conn, err1 := dial(client.addr, client.tlsConfig)
 	if err1 != nil {
 		logger.Error(err1)
 		return err1
 	}
 	client.conn = conn
+	if client.password != "" {
+		// 新连接需要重新认证，AUTH 的回复按顺序被丢弃
+		authReq := &request{args: utils.ToCmdLine("AUTH", client.password)}
+		if _, err1 = conn.Write(reply.MakeMultiBulkReply(authReq.args).ToBytes()); err1 != nil {
+			return err1
+		}
+		client.waitingReqs <- authReq
+	}
 	go func() {
 		_ = client.handleRead()
 	}()
@@ -136,6 +173,12 @@ func (client *Client) Send(args [][]byte) resp.Reply {
 	return request.reply
 }
 
+// Auth authenticates the connection with password, the client authenticates again after reconnecting
+func (client *Client) Auth(password string) resp.Reply {
+	client.password = password
+	return client.Send(utils.ToCmdLine("AUTH", password))
+}
+
 func (client *Client) doHeartbeat() {
 	request := &request{
 		args:      [][]byte{[]byte("PING")},
@@ -1,9 +1,12 @@
 package connection
 
 import (
+	"errors"
+	"go-redis/lib/logger"
 	"go-redis/lib/sync/wait"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,17 +14,59 @@ import (
 type Connection struct {
 	conn         net.Conn
 	waitingReply wait.Wait // server关闭之前需要把所有的没有执行的业务都处理完
+	// lastActive is the unix nano time of the last interaction, it is used to close idle clients
+	lastActive int64
 
 	mu         sync.Mutex //操作一个链接/客户的时候需要上锁避免并发问题
 	selectedDB int        // 指示一下当前客户正在操作哪一个数据库
+	// authenticated is true after the client sent the right password with AUTH
+	authenticated bool
+	user          string // acl user name
+
+	// 事务相关状态
+	multiState bool
+	queue      [][][]byte
+	watching   map[int]map[string]uint32 // db index -> key -> version
+	txErrors   []error
+
+	// 订阅相关状态，空闲超时检查会在其他协程读取，所以需要加锁
+	subsMu   sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// pushCh queues messages pushed to subscriber, they are written by another goroutine so PUBLISH never blocks
+	pushMu     sync.Mutex
+	pushCh     chan []byte // nil until the first push
+	pushClosed bool
+
+	// done is closed by Close, it wakes up the client blocked by commands like BLPOP
+	done    chan struct{}
+	blocked int32 // accessed atomically, the idle checker reads it from another goroutine
 }
 
+var errPushClosed = errors.New("connection is closed for being too slow to consume pushed messages")
+
+// pushQueueSize is the max number of pending pushed messages, a subscriber which can't keep up is disconnected
+const pushQueueSize = 1024
+
 func NewConn(conn net.Conn) *Connection {
 	return &Connection{
-		conn: conn,
+		conn:       conn,
+		lastActive: time.Now().UnixNano(),
+		done:       make(chan struct{}),
 	}
 }
 
+// MarkActive records the time of interaction with client
+func (c *Connection) MarkActive() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// IdleTime returns duration since the last interaction
+func (c *Connection) IdleTime() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&c.lastActive))
+}
+
 // RemoteAddr returns the remote network address
 func (c *Connection) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
@@ -29,16 +74,67 @@ func (c *Connection) RemoteAddr() net.Addr {
 
 // Close disconnect with the client
 func (c *Connection) Close() error {
+	c.pushMu.Lock()
+	if !c.pushClosed {
+		c.pushClosed = true
+		if c.pushCh != nil {
+			close(c.pushCh)
+		}
+	}
+	// pushClosed is also set when dropping a slow subscriber, so done is checked by itself
+	if c.done != nil {
+		select {
+		case <-c.done:
+		default:
+			close(c.done)
+		}
+	}
+	c.pushMu.Unlock()
 	c.waitingReply.WaitWithTimeout(10 * time.Second) //要么10秒超时要么业务结束
 	_ = c.conn.Close()
 	return nil
 }
 
+// Done returns a channel closed after the connection is closed, it is nil for fake connections
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// SetBlocked marks the client as waiting for a blocking command like BLPOP
+func (c *Connection) SetBlocked(blocked bool) {
+	var val int32
+	if blocked {
+		val = 1
+	}
+	atomic.StoreInt32(&c.blocked, val)
+}
+
+// IsBlocked tells whether the client is waiting for a blocking command
+func (c *Connection) IsBlocked() bool {
+	return atomic.LoadInt32(&c.blocked) == 1
+}
+
 // Write sends response to client over tcp connection
+// once messages are pushed to the client, replies are queued after them, so the client receives
+// confirmations of SUBSCRIBE and replies of following commands in order
 func (c *Connection) Write(b []byte) error {
 	if len(b) == 0 {
 		return nil
 	}
+	c.pushMu.Lock()
+	pushing := c.pushCh != nil
+	c.pushMu.Unlock()
+	if pushing {
+		if !c.Push(b) {
+			return errPushClosed
+		}
+		return nil
+	}
+	return c.write(b)
+}
+
+// write sends data to client immediately
+func (c *Connection) write(b []byte) error {
 	c.mu.Lock() //同一时刻只能有一个协程向客户端写数据
 	c.waitingReply.Add(1)
 	defer func() {
@@ -59,3 +155,180 @@ func (c *Connection) GetDBIndex() int {
 func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
+
+// IsAuthenticated tells whether the client has passed AUTH
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated
+}
+
+// SetAuthenticated marks the client as authenticated or not
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated = authenticated
+}
+
+// GetUser returns name of the acl user
+func (c *Connection) GetUser() string {
+	return c.user
+}
+
+// SetUser sets name of the acl user after AUTH
+func (c *Connection) SetUser(user string) {
+	c.user = user
+}
+
+// InMultiState tells is connection in an uncommitted transaction
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState sets transaction flag, queued commands and errors are dropped when leaving multi state
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine returns queued commands of current transaction
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd enqueues command of current transaction
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// AddTxError stores syntax error within transaction
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors returns syntax error within transaction
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// GetWatching returns watching keys of each db and their version code when started watching
+func (c *Connection) GetWatching() map[int]map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[int]map[string]uint32)
+	}
+	return c.watching
+}
+
+// ClearWatching forgets all watched keys
+func (c *Connection) ClearWatching() {
+	c.watching = nil
+}
+
+// Subscribe adds the channel into subscribed channels, it returns false if it is subscribed already
+func (c *Connection) Subscribe(channel string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	if _, ok := c.channels[channel]; ok {
+		return false
+	}
+	c.channels[channel] = struct{}{}
+	return true
+}
+
+// UnSubscribe removes the channel from subscribed channels, it returns false if it is not subscribed
+func (c *Connection) UnSubscribe(channel string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if _, ok := c.channels[channel]; !ok {
+		return false
+	}
+	delete(c.channels, channel)
+	return true
+}
+
+// PSubscribe adds the pattern into subscribed patterns, it returns false if it is subscribed already
+func (c *Connection) PSubscribe(pattern string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.patterns == nil {
+		c.patterns = make(map[string]struct{})
+	}
+	if _, ok := c.patterns[pattern]; ok {
+		return false
+	}
+	c.patterns[pattern] = struct{}{}
+	return true
+}
+
+// PUnSubscribe removes the pattern from subscribed patterns, it returns false if it is not subscribed
+func (c *Connection) PUnSubscribe(pattern string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if _, ok := c.patterns[pattern]; !ok {
+		return false
+	}
+	delete(c.patterns, pattern)
+	return true
+}
+
+// SubsCount returns the number of subscribed channels and patterns, the client is in subscribe mode if it is positive
+func (c *Connection) SubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.channels) + len(c.patterns)
+}
+
+// GetChannels returns subscribed channels
+func (c *Connection) GetChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns returns subscribed patterns
+func (c *Connection) GetPatterns() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	patterns := make([]string, 0, len(c.patterns))
+	for pattern := range c.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// Push queues data to client without blocking, it is used to deliver messages to subscribers.
+// if the queue is full, the client is too slow to consume messages and is disconnected, Push returns false
+func (c *Connection) Push(b []byte) bool {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	if c.pushClosed {
+		return false
+	}
+	if c.pushCh == nil {
+		c.pushCh = make(chan []byte, pushQueueSize)
+		go c.servePush(c.pushCh)
+	}
+	select {
+	case c.pushCh <- b:
+		return true
+	default:
+	}
+	// 关闭连接后读取协程会返回错误，由 handler 完成清理
+	logger.Warn("closing slow subscriber: " + c.conn.RemoteAddr().String())
+	c.pushClosed = true
+	close(c.pushCh)
+	_ = c.conn.Close()
+	return false
+}
+
+func (c *Connection) servePush(ch <-chan []byte) {
+	for b := range ch {
+		_ = c.write(b)
+	}
+}
@@ -19,10 +19,12 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
-	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
+	unknownErrReplyBytes    = []byte("-ERR unknown\r\n")
+	maxClientsErrReplyBytes = []byte("-ERR max number of clients reached\r\n")
 )
 
 // RespHandler implements tcp.Handler and serves as a redis handler
@@ -30,6 +32,9 @@ type RespHandler struct {
 	activeConn sync.Map // *client -> placeholder //多个客户端链接
 	db         databaseface.Database
 	closing    atomic.Boolean // refusing new client and new request
+
+	countMu     sync.Mutex
+	clientCount int // number of active clients, limited by maxclients
 }
 
 // MakeHandler creates a RespHandler instance
@@ -42,9 +47,30 @@ func MakeHandler() *RespHandler { // 既可以单机又可以联机
 		db = database.NewStandaloneDatabase()
 	}
 
-	return &RespHandler{
+	h := &RespHandler{
 		db: db,
 	}
+	if config.Properties.Timeout > 0 {
+		go h.serveIdleTimeout(time.Duration(config.Properties.Timeout) * time.Second)
+	}
+	return h
+}
+
+// acquireClient counts a new client, it returns false if maxclients is reached
+func (h *RespHandler) acquireClient() bool {
+	h.countMu.Lock()
+	defer h.countMu.Unlock()
+	if config.Properties.MaxClients > 0 && h.clientCount >= config.Properties.MaxClients {
+		return false
+	}
+	h.clientCount++
+	return true
+}
+
+func (h *RespHandler) releaseClient() {
+	h.countMu.Lock()
+	h.clientCount--
+	h.countMu.Unlock()
 }
 func (h *RespHandler) closeClient(client *connection.Connection) { //关闭一个client链接
 	_ = client.Close()
@@ -57,17 +83,22 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if h.closing.Get() { //判断server是否是在关闭中
 		// closing handler refuse new connection
 		_ = conn.Close()
+		return
 	}
+	if !h.acquireClient() {
+		_, _ = conn.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		return
+	}
+	defer h.releaseClient()
 
 	client := connection.NewConn(conn)
 	h.activeConn.Store(client, 1)
 
-	ch := parser.ParseStream(conn) //这个函数创建一个管道，并启动一个协程，然后返回管道。这个协程负责解析client的指令，指令解析后发到管道里
-	for payload := range ch {      //for -range 监听一个管道，并陷入阻塞状态，是个死循环，如果不关闭的话
+	ch := watchDisconnect(client, parser.ParseStream(conn)) //这个函数创建一个管道，并启动一个协程，然后返回管道。这个协程负责解析client的指令，指令解析后发到管道里
+	for payload := range ch {                               //for -range 监听一个管道，并陷入阻塞状态，是个死循环，如果不关闭的话
 		if payload.Err != nil { //判断错误逻辑
-			if payload.Err == io.EOF || //io.EOF 说明Client退出链接，想四次挥手
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") { //使用一个关闭连接
+			if isClosedErr(payload.Err) {
 				// connection closed
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
@@ -92,12 +123,59 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			logger.Error("require multi bulk reply")
 			continue
 		}
+		client.MarkActive()
 		result := h.db.Exec(client, r.Args)
 		if result != nil {
 			_ = client.Write(result.ToBytes())
 		} else {
 			_ = client.Write(unknownErrReplyBytes) //如果结果仍为空，就报未知错误
 		}
+		client.MarkActive() // 回复较大时写入也可能耗时较长
+	}
+}
+
+// isClosedErr tells whether the client disconnected
+func isClosedErr(err error) bool {
+	return err == io.EOF || //io.EOF 说明Client退出链接，想四次挥手
+		err == io.ErrUnexpectedEOF ||
+		strings.Contains(err.Error(), "use of closed network connection") //使用一个关闭连接
+}
+
+// watchDisconnect forwards payloads to Handle. Handle can't receive payloads while a command like BLPOP is blocking,
+// so the client is closed here as soon as it disconnects, which wakes up the blocked command.
+// Handle still does the cleanup after receiving the error
+func watchDisconnect(client *connection.Connection, payloads <-chan *parser.Payload) <-chan *parser.Payload {
+	ch := make(chan *parser.Payload)
+	go func() {
+		defer close(ch)
+		for payload := range payloads {
+			if payload.Err != nil && isClosedErr(payload.Err) {
+				_ = client.Close()
+			}
+			ch <- payload
+		}
+	}()
+	return ch
+}
+
+// serveIdleTimeout closes clients which sent nothing for longer than timeout
+func (h *RespHandler) serveIdleTimeout(timeout time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if h.closing.Get() {
+			return
+		}
+		h.activeConn.Range(func(key interface{}, val interface{}) bool {
+			client := key.(*connection.Connection)
+			// subscribers and blocked clients may wait for a long time
+			if client.SubsCount() == 0 && !client.IsBlocked() && client.IdleTime() > timeout {
+				logger.Info("closing idle client: " + client.RemoteAddr().String())
+				// Handle 中的读取会返回错误，由它完成清理
+				_ = client.Close()
+			}
+			return true
+		})
 	}
 }
 
@@ -23,6 +23,9 @@ import (
 type Payload struct {
 	Data resp.Reply
 	Err  error
+	// Offset is the number of bytes consumed from the stream when the payload is sent,
+	// for a Data payload it is the end of the reply
+	Offset int64
 }
 
 // ParseStream reads data from io.Reader and send payloads through channel
@@ -40,6 +43,7 @@ type readState struct {
 	msgType           byte     //用户消息类型
 	args              [][]byte // 用户发来的消息的解析
 	bulkLen           int64    //期望收到字符串的长度
+	readingBody       bool     // 已读到 $n，下一次按长度读取 n 个字节（n 可以为 0）
 }
 
 //判断解析器是否以及完成
@@ -65,23 +69,33 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 	var state readState
 	var err error
 	var msg []byte
+	var offset int64 // bytes consumed
+	send := func(p *Payload) {
+		p.Offset = offset
+		ch <- p
+	}
 	for {
 		// read line
 		var ioErr bool
 		msg, ioErr, err = readLine(bufReader, &state) //读进来一行数据
+		offset += int64(len(msg))
 
 		if err != nil {
 			if ioErr { // encounter io err, stop read 如果是io err 直接放到管道里，解析任务结束
-				ch <- &Payload{
-					Err: err,
+				if err == io.EOF && (len(msg) > 0 || state.readingMultiLine || state.readingBody) {
+					// the stream ends in the middle of a reply
+					err = io.ErrUnexpectedEOF
 				}
+				send(&Payload{
+					Err: err,
+				})
 				close(ch)
 				return
 			}
 			// protocol err, reset read state  如果是协议错误，返回错误并重置解析器的状态
-			ch <- &Payload{
+			send(&Payload{
 				Err: err,
-			}
+			})
 			state = readState{}
 			continue
 		}
@@ -93,42 +107,42 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 				// multi bulk reply
 				err = parseMultiBulkHeader(msg, &state)
 				if err != nil {
-					ch <- &Payload{
+					send(&Payload{
 						Err: errors.New("protocol error: " + string(msg)),
-					}
+					})
 					state = readState{} // reset state
 					continue
 				}
 				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
+					send(&Payload{
 						Data: &reply.EmptyMultiBulkReply{},
-					}
+					})
 					state = readState{} // reset state
 					continue
 				}
 			} else if msg[0] == '$' { // bulk reply
 				err = parseBulkHeader(msg, &state)
 				if err != nil {
-					ch <- &Payload{
+					send(&Payload{
 						Err: errors.New("protocol error: " + string(msg)),
-					}
+					})
 					state = readState{} // reset state
 					continue
 				}
 				if state.bulkLen == -1 { // null bulk reply //-1表示空的 //$-1\r\n
-					ch <- &Payload{
+					send(&Payload{
 						Data: &reply.NullBulkReply{},
-					}
+					})
 					state = readState{} // reset state
 					continue
 				}
 			} else {
 				// single line reply
 				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
+				send(&Payload{
 					Data: result,
 					Err:  err,
-				}
+				})
 				state = readState{} // reset state
 				continue
 			}
@@ -145,9 +159,9 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 			// receive following bulk reply
 			err = readBody(msg, &state)
 			if err != nil {
-				ch <- &Payload{
+				send(&Payload{
 					Err: errors.New("protocol error: " + string(msg)),
-				}
+				})
 				state = readState{} // reset state
 				continue
 			}
@@ -159,10 +173,10 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 				} else if state.msgType == '$' {
 					result = reply.MakeBulkReply(state.args[0])
 				}
-				ch <- &Payload{
+				send(&Payload{
 					Data: result,
 					Err:  err,
-				}
+				})
 				state = readState{}
 			}
 		}
@@ -170,26 +184,30 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 }
 
 // readLine 从io.read里取数据，一行一行的取，以\n为结尾符号
-// bool表示是否是io错误
+// bool表示是否是io错误, the partial line read before io error is returned too
 func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	var msg []byte
 	var err error
 	// 1. 没有读到$,以\r\n切分
 
-	if state.bulkLen == 0 { // 1. \r\n切分 此时以\n切分
+	if !state.readingBody { // 1. \r\n切分 此时以\n切分
 		msg, err = bufReader.ReadBytes('\n')
 		if err != nil {
-			return nil, true, err
+			return msg, true, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' { //要么消息len为0,要么读的不对，倒数第二个不是\r
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' { //要么消息太短,要么读的不对，倒数第二个不是\r
 			return nil, false, errors.New("protocol error: " + string(msg))
 		}
 		// 2. 之前读到了$数字，严格读取字符个数
 	} else { // read bulk line (binary safe)
 		msg = make([]byte, state.bulkLen+2)
-		_, err = io.ReadFull(bufReader, msg)
+		var n int
+		n, err = io.ReadFull(bufReader, msg)
 		if err != nil {
-			return nil, true, err //有io错误
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF // header is read but body is missing
+			}
+			return msg[:n], true, err //有io错误
 		}
 		if len(msg) == 0 ||
 			msg[len(msg)-2] != '\r' ||
@@ -258,9 +276,10 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 { // $0\r\n\r\n is an empty string
 		state.msgType = msg[0]
 		state.readingMultiLine = true
+		state.readingBody = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
 		return nil
@@ -292,15 +311,21 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 func readBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2] //切去\r\n
 	var err error
-	if line[0] == '$' {
+	if state.readingBody {
+		// body of bulk, it may be empty or start with '$'
+		state.args = append(state.args, line)
+		state.readingBody = false
+	} else if len(line) > 0 && line[0] == '$' {
 		// bulk reply
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
+		if err != nil || state.bulkLen < -1 {
 			return errors.New("protocol error: " + string(msg))
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
+		if state.bulkLen == -1 { // null bulk in multi bulks
 			state.args = append(state.args, []byte{})
 			state.bulkLen = 0
+		} else {
+			state.readingBody = true
 		}
 	} else {
 		state.args = append(state.args, line)
@@ -43,6 +43,23 @@ func MakeNullBulkReply() *NullBulkReply {
 	return &NullBulkReply{}
 }
 
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply is a null array, e.g. the reply of LPOP key count when key not exists
+type NullMultiBulkReply struct{}
+
+// ToBytes marshal redis.Reply
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+var theNullMultiBulkReply = new(NullMultiBulkReply)
+
+// MakeNullMultiBulkReply returns a null array reply
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return theNullMultiBulkReply
+}
+
 var emptyMultiBulkBytes = []byte("*0\r\n")
 
 // EmptyMultiBulkReply is a empty list
@@ -62,3 +79,20 @@ var noBytes = []byte("")
 func (r *NoReply) ToBytes() []byte {
 	return noBytes
 }
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+// QueuedReply is +QUEUED, the reply of commands queued in a transaction
+type QueuedReply struct{}
+
+// ToBytes marshal redis.Reply
+func (r *QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+// MakeQueuedReply returns a QUEUED reply
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
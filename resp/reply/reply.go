@@ -15,7 +15,7 @@ ErrorReply回复错误
 */
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
+	nullBulkReplyBytes = []byte("$-1\r\n")
 
 	// CRLF is the line separator of redis serialization protocol
 	CRLF = "\r\n"
@@ -35,9 +35,9 @@ func MakeBulkReply(arg []byte) *BulkReply {
 	}
 }
 
-// ToBytes marshal redis.Reply
+// ToBytes marshal redis.Reply, nil Arg means null bulk while empty Arg is an empty string
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
+	if r.Arg == nil {
 		return nullBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -73,6 +73,32 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply store complex list structure, for example GeoPos command
+// 嵌套数组的回复，比如 SCAN 的 [cursor, [keys...]]
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 // StatusReply /* ---- Status Reply ---- */
 // 回复一个状态/////////////////////////////////////////////////////////////////////////////////
 // StatusReply stores a simple status string
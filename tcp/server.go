@@ -2,6 +2,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"go-redis/interface/tcp"
 	"go-redis/lib/logger"
@@ -10,18 +12,25 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // Config stores tcp server properties
 type Config struct {
-	Address string
+	Address string // plaintext address, empty to disable
+	// TLSAddress is the address serving tls, empty to disable. both addresses can be served at the same time
+	TLSAddress string
+	TLSConfig  *tls.Config
 }
 
+// handshakeTimeout limits the time of tls handshake, so a client can't hold a connection without finishing it
+const handshakeTimeout = 10 * time.Second
+
 // ListenAndServeWithSignal binds port and handle requests, blocking until receive stop signal
 // 创建一个os lever chan和子协程监听系统是否发来关闭信号，如果发来信号，则向closeChan写入空结构体，通知关闭
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		sig := <-sigCh
@@ -30,12 +39,33 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 			closeChan <- struct{}{}
 		}
 	}()
-	listener, err := net.Listen("tcp", cfg.Address)
-	if err != nil {
-		return err
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+	}
+	if cfg.TLSAddress != "" {
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening tls...", cfg.TLSAddress))
+	}
+	if len(listeners) == 0 {
+		return errors.New("no address to listen")
 	}
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	ListenAndServe(listeners, handler, closeChan)
 	return nil
 }
 
@@ -44,39 +74,69 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 // ListenAndServe 两个逻辑：一个是正常关闭，二是我们手动关闭进程，导致无法执行到defer中释放资源。
 // 一是正常关闭，需要考虑到 连接到坏请求导致退出，但是好请求还没有处理完毕，所以需要等待它们执行结束，加个waitGroup
 // 二是特殊关闭，所以创建一个协程 一直读取管道，如果管道发来空接口体（信号）就执行关闭，并释放资源，信号由ListenAndServeWithSignal发送
-func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+// 任意一个 listener 出错时关闭所有 listener，handler 只关闭一次
+func ListenAndServe(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	var closeOnce sync.Once
+	closeAll := func() {
+		closeOnce.Do(func() {
+			for _, listener := range listeners {
+				_ = listener.Close() // listener.Accept() will return err immediately
+			}
+			_ = handler.Close() // close connections
+		})
+	}
+
 	// listen signal
 	go func() {
 		<-closeChan
 		logger.Info("shutting down...")
-		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
+		closeAll()
 	}()
 
 	// listen port
-	defer func() {
-		// close during unexpected error
-		_ = listener.Close()
-		_ = handler.Close()
-	}()
 	ctx := context.Background()
-
 	var waitDone sync.WaitGroup
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			break
-		}
-		// handle
-		logger.Info("accept link")
-		waitDone.Add(1)
-		go func() {
+	var acceptDone sync.WaitGroup
+	for _, listener := range listeners {
+		acceptDone.Add(1)
+		go func(listener net.Listener) {
 			defer func() {
-				waitDone.Done()
+				// close during unexpected error
+				closeAll()
+				acceptDone.Done()
 			}()
-			handler.Handle(ctx, conn)
-		}()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					break
+				}
+				// handle
+				logger.Info("accept link")
+				waitDone.Add(1)
+				go func() {
+					defer func() {
+						waitDone.Done()
+					}()
+					if tlsConn, ok := conn.(*tls.Conn); ok && !handshake(tlsConn) {
+						return
+					}
+					handler.Handle(ctx, conn)
+				}()
+			}
+		}(listener)
 	}
+	acceptDone.Wait()
 	waitDone.Wait() // 加等待队列的意义：遇到错误链接Break后，需要把其他协程处理函数处理完后main才能退出
+}
 
+// handshake finishes tls handshake before handing the connection to handler, it closes the connection on failure
+func handshake(conn *tls.Conn) bool {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		logger.Warn("tls handshake failed: " + conn.RemoteAddr().String() + ": " + err.Error())
+		_ = conn.Close()
+		return false
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return true
 }